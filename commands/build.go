@@ -2,15 +2,19 @@ package commands
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"path"
+	"sort"
+	"strings"
 
 	"github.com/contextcloud/ccb/pkg/builder"
 	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/policy"
 	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/templater"
 	"github.com/contextcloud/ccb/pkg/utils"
-	cliconfig "github.com/docker/cli/cli/config"
 
 	"github.com/spf13/cobra"
 )
@@ -21,13 +25,23 @@ type buildOptions struct {
 	network    string
 	buildArgs  []string
 
-	push bool
+	push    bool
+	offline bool
 
 	tag      string
 	registry string
 	prefix   string
-	username string
-	password string
+	source   string
+	commit   string
+
+	username      string
+	password      string
+	passwordStdin bool
+
+	policyFile string
+
+	signKey string
+	sbom    string
 
 	poolSize int
 }
@@ -43,9 +57,10 @@ func newBuildCommand() *cobra.Command {
 		Long:  `build makes docker images from our packed dirs`,
 		Example: `
   ccb build -f https://domain/path/stack.yml
-  ccb build -f ./stack.yml`,
+  ccb build -f ./stack.yml
+  ccb build --offline`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runBuild(logger, options, args)
+			return runBuild(logger, options, cmd.InOrStdin(), args)
 		},
 	}
 
@@ -58,19 +73,58 @@ func newBuildCommand() *cobra.Command {
 	flags.StringSliceVarP(&options.buildArgs, "build-args", "", []string{}, "To be parsed as a key=value pair to docker build")
 
 	flags.BoolVarP(&options.push, "push", "", false, "If true, push images to registry")
+	flags.BoolVarP(&options.offline, "offline", "", false, "Only use cached or vendored templates")
 
 	flags.StringVarP(&options.tag, "tag", "t", "latest", "The tag for the containers")
 	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker images")
 	flags.StringVarP(&options.prefix, "prefix", "", "", "The prefix for the docker image name")
+	flags.StringVarP(&options.source, "source", "", "", "The source repository stamped on the images")
+	flags.StringVarP(&options.commit, "commit", "", "", "The commit stamped on the images")
 	flags.StringVarP(&options.username, "username", "", "", "The username for the registry")
 	flags.StringVarP(&options.password, "password", "", "", "The password for the registry")
+	flags.BoolVarP(&options.passwordStdin, "password-stdin", "", false, "Take the password for the registry from stdin")
+
+	flags.StringVarP(&options.policyFile, "policy", "", defaultPolicyFile, "Path to the image policy file, relative to the working directory")
+
+	flags.StringVarP(&options.signKey, "sign-key", "", "", "Path to a private key to sign pushed images with")
+	flags.StringVarP(&options.sbom, "sbom", "", "", "Attach an sbom to pushed images, spdx or cyclonedx")
 
 	flags.IntVarP(&options.poolSize, "pool-size", "", 1, "How many containers to build together")
 
 	return cmd
 }
 
-func runBuild(logger print.Logger, opts buildOptions, args []string) error {
+func readPassword(opts buildOptions, stdin io.Reader) (string, error) {
+	if !opts.passwordStdin {
+		return opts.password, nil
+	}
+	if opts.password != "" {
+		return "", errors.New("--password and --password-stdin are mutually exclusive")
+	}
+	if opts.username == "" {
+		return "", errors.New("must provide --username with --password-stdin")
+	}
+
+	raw, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+func runBuild(logger print.Logger, opts buildOptions, stdin io.Reader, args []string) error {
+	if (opts.signKey != "" || opts.sbom != "") && !opts.push {
+		return errors.New("--sign-key and --sbom require --push")
+	}
+	if opts.offline && opts.push {
+		return errors.New("--push can't be used with --offline")
+	}
+
+	password, err := readPassword(opts, stdin)
+	if err != nil {
+		return err
+	}
+
 	stackFile := path.Join(opts.workingDir, opts.stackFile)
 
 	stack, err := parser.LoadStack(stackFile)
@@ -93,53 +147,107 @@ func runBuild(logger print.Logger, opts buildOptions, args []string) error {
 		return err
 	}
 
-	registry := "https://index.docker.io/v1/"
-	if opts.registry != "" {
-		registry = opts.registry
+	ctx := context.Background()
+	if opts.offline {
+		sources, err := templateSources(stack, nil)
+		if err != nil {
+			return err
+		}
+
+		// materialize templates from the cache or vendor directory
+		if _, err := downloadTemplates(ctx, fns, &templater.Options{
+			WorkingDir: opts.workingDir,
+			Sources:    sources,
+			Offline:    true,
+		}); err != nil {
+			return err
+		}
 	}
 
-	cfg, err := cliconfig.Load("")
+	imagePolicy, err := policy.Load(path.Join(opts.workingDir, opts.policyFile))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	ac, _ := cfg.GetAuthConfig(registry)
-	if opts.username != "" {
-		ac.Username = opts.username
-	}
-	if opts.password != "" {
-		ac.Password = opts.password
+	auth, err := builder.NewDockerAuth(opts.registry, opts.username, password)
+	if err != nil {
+		return err
 	}
 
-	authConfigBytes, _ := json.Marshal(ac)
-	registryAuth := base64.URLEncoding.EncodeToString(authConfigBytes)
-
 	buildOptions := &builder.Options{
 		Log:        logger.Out(),
 		WorkingDir: opts.workingDir,
 		PoolSize:   opts.poolSize,
 		Network:    opts.network,
 
-		Push:         opts.push,
-		Registry:     opts.registry,
-		Prefix:       opts.prefix,
-		Tag:          opts.tag,
-		RegistryAuth: registryAuth,
+		Push:     opts.push,
+		Registry: opts.registry,
+		Prefix:   opts.prefix,
+		Tag:      opts.tag,
+		Auth:     auth,
+
+		Source:   opts.source,
+		Revision: opts.commit,
+
+		Policy: imagePolicy,
+
+		SignKey: opts.signKey,
+		SBOM:    opts.sbom,
+		Version: Version,
 	}
 	b, err := builder.NewBuilder(buildOptions)
 	if err != nil {
 		return err
 	}
 
+	lock, err := templater.LoadLock(opts.workingDir)
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[string]bool)
 	for _, fn := range fns {
 		// Args!
 		args := utils.MergeMap(gargs, fn.BuildArgs)
 
-		// Need to fetch templates.
-		b.AddService(fn.Key, fn.Template, args)
+		var labels map[string]string
+		if fn.Labels != nil {
+			labels = *fn.Labels
+		}
+
+		var templateRef string
+		if entry, ok := lock.Get(fn.Template); ok {
+			templateRef = entry.Resolved
+		}
+
+		err := b.AddService(&builder.Service{
+			Name:        fn.Key,
+			Template:    fn.Template,
+			TemplateRef: templateRef,
+			Version:     fn.Version,
+			Args:        args,
+			Labels:      labels,
+		})
+		if errors.Is(err, templater.ErrMissingTemplate) {
+			missing[fn.Template] = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn.Key, err)
+		}
+	}
+
+	// fail before building anything so all of them can be fetched at once
+	if len(missing) > 0 {
+		var templates []string
+		for template := range missing {
+			templates = append(templates, template)
+		}
+		sort.Strings(templates)
+		return fmt.Errorf("missing templates %s, run ccb fetch or ccb vendor", strings.Join(templates, ", "))
 	}
 
-	built, err := b.Build(context.Background())
+	built, err := b.Build(ctx)
 	if err != nil {
 		return err
 	}
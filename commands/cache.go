@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/templater"
+	"github.com/docker/go-units"
+
+	"github.com/spf13/cobra"
+)
+
+type cachePruneOptions struct {
+	olderThan time.Duration
+	all       bool
+}
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   `cache`,
+		Short: "cache manages the shared template cache",
+		Long:  `cache lists and prunes the templates shared across projects`,
+		Example: `
+  ccb cache ls
+  ccb cache prune --older-than 168h
+  ccb cache prune --all`,
+	}
+
+	cmd.AddCommand(newCacheListCommand())
+	cmd.AddCommand(newCachePruneCommand())
+
+	return cmd
+}
+
+func newCacheListCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+
+	return &cobra.Command{
+		Use:     `ls`,
+		Aliases: []string{"list"},
+		Short:   "ls lists the cached templates",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheList(logger)
+		},
+	}
+}
+
+func newCachePruneCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := cachePruneOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `prune`,
+		Short: "prune removes templates that haven't been used recently",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCachePrune(logger, options)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.DurationVarP(&options.olderThan, "older-than", "", 30*24*time.Hour, "Remove templates not used within this duration")
+	flags.BoolVarP(&options.all, "all", "a", false, "Remove every cached template")
+
+	return cmd
+}
+
+func runCacheList(logger print.Logger) error {
+	cache, err := templater.NewCache("")
+	if err != nil {
+		return err
+	}
+
+	entries, err := cache.List()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		logger.Err().Println("No cached templates in", cache.Dir)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSOURCE\tRESOLVED\tSIZE\tLAST USED")
+	for _, entry := range entries {
+		size, err := dirSize(cache.Path(entry.Key))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Key[:12],
+			entry.Source,
+			shortSha(entry.Resolved),
+			units.HumanSize(float64(size)),
+			entry.LastUsed.Local().Format(time.RFC3339),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	logger.Out().Print(buf.String())
+	return nil
+}
+
+func runCachePrune(logger print.Logger, opts cachePruneOptions) error {
+	cache, err := templater.NewCache("")
+	if err != nil {
+		return err
+	}
+
+	before := time.Now().Add(-opts.olderThan)
+	if opts.all {
+		before = time.Time{}
+	}
+
+	pruned, err := cache.Prune(before)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range pruned {
+		logger.Out().Printf("Removed %s@%s\n", entry.Source, shortSha(entry.Resolved))
+	}
+	logger.Out().Printf("Pruned %d templates\n", len(pruned))
+	return nil
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
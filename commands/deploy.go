@@ -0,0 +1,218 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/kube"
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+
+	"github.com/spf13/cobra"
+)
+
+type deployOptions struct {
+	stackFile  string
+	workingDir string
+	tag        string
+	registry   string
+	namespace  string
+	commit     string
+	router     string
+	issuer     string
+
+	kubeconfig  string
+	kubecontext string
+
+	skipRoutes bool
+	force      bool
+	prune      bool
+	yes        bool
+	dryRun     bool
+	wait       bool
+	timeout    time.Duration
+}
+
+func newDeployCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := deployOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `deploy [filters...]`,
+		Short: "deploys functions to Kubernetes",
+		Long:  `deploys the generated manifests with server side apply and waits for the rollouts`,
+		Example: `
+  ccb deploy -n dev --tag 1.2.0
+  ccb deploy "api*" --context staging --wait=false
+  ccb deploy --prune --dry-run
+  ccb deploy --prune --yes`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDeploy(logger, options, args)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.tag, "tag", "t", "latest", "The tag for the containers")
+	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker containers")
+	flags.StringVarP(&options.namespace, "namespace", "n", "", "The namespace, defaults to the one of the context")
+	flags.StringVarP(&options.commit, "commit", "", "", "The commit label")
+	flags.StringVarP(&options.router, "router", "", "", "The router to render the routes for, nginx, gateway-api or ingress, defaults to the one of the stack")
+	flags.StringVarP(&options.issuer, "issuer", "", "", "The cert-manager issuer of the certificates, defaults to the one of the stack")
+	flags.StringVarP(&options.kubeconfig, "kubeconfig", "", "", "Path to the kubeconfig file")
+	flags.StringVarP(&options.kubecontext, "context", "", "", "The kubeconfig context to use")
+	flags.BoolVarP(&options.skipRoutes, "skip-routes", "", false, "Don't apply the routes of the stack")
+	flags.BoolVarP(&options.force, "force-conflicts", "", false, "Take ownership of fields other managers own")
+	flags.BoolVarP(&options.prune, "prune", "", false, "Delete resources of the stack that are no longer in it, the stack file must set its name")
+	flags.BoolVarP(&options.yes, "yes", "", false, "Confirm the resources listed by --prune --dry-run can be deleted")
+	flags.BoolVarP(&options.dryRun, "dry-run", "", false, "Check with the server and list what would be pruned without changing anything")
+	flags.BoolVarP(&options.wait, "wait", "", true, "Wait for the deployments to roll out")
+	flags.DurationVarP(&options.timeout, "timeout", "", 5*time.Minute, "How long to wait for each rollout")
+
+	return cmd
+}
+
+func runDeploy(logger print.Logger, opts deployOptions, args []string) error {
+	// everything the stack renders is needed to know what's left over
+	if opts.prune && (len(args) > 0 || opts.skipRoutes) {
+		return errors.New("--prune can't be used with filters or --skip-routes")
+	}
+	// pruning deletes, what would go is listed with --dry-run first
+	if opts.prune && !opts.dryRun && !opts.yes {
+		return errors.New("--prune deletes resources, list them with --dry-run and confirm with --yes")
+	}
+
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	// a stack named after its directory would prune any other stack in a
+	// directory called the same
+	if opts.prune && !stack.IsNamed() {
+		return fmt.Errorf("%w: set name in %s to use --prune", parser.ErrUnnamedStack, stackFile)
+	}
+
+	fns, err := stack.GetFunctions(args...)
+	if err != nil {
+		return err
+	}
+
+	if len(fns) == 0 {
+		logger.Err().Println("No functions found")
+		return nil
+	}
+
+	client, err := kube.NewClient(logger.Out(), opts.kubeconfig, opts.kubecontext, opts.namespace)
+	if err != nil {
+		return err
+	}
+
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoutes(logger, stack); err != nil {
+		return err
+	}
+
+	router, err := stackRouter(stack, opts.router, opts.issuer)
+	if err != nil {
+		return err
+	}
+
+	de := deployer.NewManager(deployer.Options{
+		WorkingDir: opts.workingDir,
+		Namespace:  client.Namespace,
+		Commit:     opts.commit,
+		Stack:      stack.GetName(),
+		Releases:   releases,
+		Router:     router,
+		Network:    stack.GetNetwork(),
+		Callers:    stack.GetCallers(),
+	})
+
+	manifests, err := renderManifests(de, stack, fns, opts.registry, opts.tag, opts.skipRoutes)
+	if err != nil {
+		return err
+	}
+
+	objs, err := kube.Decode(manifests)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Out().Printf("Deploying %d resources to %s\n", len(objs), client.Namespace)
+	applied, err := client.Apply(ctx, objs, kube.ApplyOptions{
+		Force:  opts.force,
+		DryRun: opts.dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.prune {
+		pruned, err := client.Prune(ctx, stack.GetName(), applied, opts.dryRun)
+		if err != nil {
+			return err
+		}
+		if len(pruned) == 0 {
+			logger.Out().Println("Nothing to prune")
+		}
+	}
+
+	if !opts.wait || opts.dryRun {
+		return nil
+	}
+	return client.WaitRollouts(ctx, applied, opts.timeout)
+}
+
+// stackRouter is the router of the stack, the flags win when they're set
+func stackRouter(stack parser.Stack, kind string, issuer string) (manifests.Router, error) {
+	r := stack.GetRouter()
+	if kind != "" {
+		r.Kind = kind
+	}
+	if issuer != "" {
+		r.Issuer = issuer
+	}
+	return r, parser.ValidateRouter(r)
+}
+
+// renderManifests of the functions and the routes of the stack unless they're skipped
+func renderManifests(de deployer.Manager, stack parser.Stack, fns []*parser.Function, registry string, tag string, skipRoutes bool) (deployer.Manifests, error) {
+	manifests, err := de.GenerateFunctions(registry, tag, fns)
+	if err != nil {
+		return nil, err
+	}
+	if skipRoutes {
+		return manifests, nil
+	}
+
+	routes, err := stack.GetRoutes()
+	if err != nil {
+		return nil, err
+	}
+	out, err := de.GenerateRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	return append(manifests, out...), nil
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/watcher"
+
+	"github.com/spf13/cobra"
+)
+
+type devOptions struct {
+	upOptions
+
+	watch    bool
+	debounce time.Duration
+}
+
+func newDevCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := devOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `dev [filters...]`,
+		Short: "dev runs the stack locally while you work on it",
+		Long:  `dev runs the stack like up, with --watch functions are rebuilt and restarted when their files change`,
+		Example: `
+  ccb dev --watch
+  ccb dev "api*" --watch --debounce 1s`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDev(logger, options, args)
+		},
+	}
+
+	addUpFlags(cmd, &options.upOptions)
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&options.watch, "watch", "w", false, "Rebuild and restart functions when their files change")
+	flags.DurationVarP(&options.debounce, "debounce", "", watcher.DefaultDebounce, "How long to wait for changes to settle")
+
+	return cmd
+}
+
+func runDev(logger print.Logger, opts devOptions, filters []string) error {
+	if !opts.watch {
+		return runUp(logger, opts.upOptions, filters, nil)
+	}
+
+	return runUp(logger, opts.upOptions, filters, func(ctx context.Context, s *localStack) error {
+		dirs := make(map[string]string)
+		for _, key := range s.keys {
+			dirs[key] = path.Join(opts.workingDir, key)
+		}
+
+		w, err := watcher.NewWatcher(logger.Err(), dirs, opts.debounce)
+		if err != nil {
+			return err
+		}
+
+		logger.Out().Printf("Watching %s\n", strings.Join(s.keys, ", "))
+		return w.Run(ctx, func(keys []string) {
+			rebuild(ctx, logger, opts.runOptions, s, keys)
+		})
+	})
+}
+
+// rebuild the functions that changed and restart them, errors are shown and
+// the watch carries on with the containers that are running
+func rebuild(ctx context.Context, logger print.Logger, opts runOptions, s *localStack, keys []string) {
+	logger.Out().Printf("Changed %s, rebuilding\n", strings.Join(keys, ", "))
+
+	if err := runBuild(logger, opts.buildOptions(), os.Stdin, keys); err != nil {
+		logger.Err().Printf("Build failed: %s\n", err)
+		return
+	}
+
+	for _, key := range keys {
+		if err := s.restart(ctx, key); err != nil {
+			logger.Err().Printf("%s: Restart failed: %s\n", key, err)
+			continue
+		}
+		logger.Out().Printf("%s: Restarted\n", key)
+	}
+}
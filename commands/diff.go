@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/kube"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ErrChanges when diff finds changes and --exit-code is set
+var ErrChanges = errors.New("there are changes")
+
+type diffOptions struct {
+	stackFile  string
+	workingDir string
+	tag        string
+	registry   string
+	namespace  string
+	commit     string
+	router     string
+	issuer     string
+
+	against     string
+	kubeconfig  string
+	kubecontext string
+	skipRoutes  bool
+	exitCode    bool
+}
+
+func newDiffCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := diffOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `diff [filters...]`,
+		Short: "diff shows what a deploy would change",
+		Long:  `diff renders the manifests like generate and compares them with a previous output or the live cluster`,
+		Example: `
+  ccb diff -n dev --tag 1.2.0
+  ccb diff --against ./previous.yaml
+  ccb diff --against ./manifests --exit-code`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(logger, options, args)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.tag, "tag", "t", "latest", "The tag for the containers")
+	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker containers")
+	flags.StringVarP(&options.namespace, "namespace", "n", "", "The namespace, defaults to the one of the context")
+	flags.StringVarP(&options.commit, "commit", "", "", "The commit label")
+	flags.StringVarP(&options.router, "router", "", "", "The router to render the routes for, nginx, gateway-api or ingress, defaults to the one of the stack")
+	flags.StringVarP(&options.issuer, "issuer", "", "", "The cert-manager issuer of the certificates, defaults to the one of the stack")
+	flags.StringVarP(&options.against, "against", "", "", "A manifest file or directory to compare with instead of the cluster")
+	flags.StringVarP(&options.kubeconfig, "kubeconfig", "", "", "Path to the kubeconfig file")
+	flags.StringVarP(&options.kubecontext, "context", "", "", "The kubeconfig context to use")
+	flags.BoolVarP(&options.skipRoutes, "skip-routes", "", false, "Don't compare the routes of the stack")
+	flags.BoolVarP(&options.exitCode, "exit-code", "", false, "Exit with an error when there are changes")
+
+	return cmd
+}
+
+func runDiff(logger print.Logger, opts diffOptions, args []string) error {
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	// what was removed is found by the name, one taken from the directory
+	// would list the resources of any stack in a directory called the same
+	wholeStack := len(args) == 0 && !opts.skipRoutes
+	if opts.against == "" && wholeStack && !stack.IsNamed() {
+		return fmt.Errorf("%w: set name in %s to compare with the cluster, or use filters or --skip-routes", parser.ErrUnnamedStack, stackFile)
+	}
+
+	fns, err := stack.GetFunctions(args...)
+	if err != nil {
+		return err
+	}
+
+	if len(fns) == 0 {
+		logger.Err().Println("No functions found")
+		return nil
+	}
+
+	var client *kube.Client
+	namespace := opts.namespace
+	if opts.against == "" {
+		if client, err = kube.NewClient(logger.Err(), opts.kubeconfig, opts.kubecontext, opts.namespace); err != nil {
+			return err
+		}
+		namespace = client.Namespace
+	}
+
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoutes(logger, stack); err != nil {
+		return err
+	}
+
+	router, err := stackRouter(stack, opts.router, opts.issuer)
+	if err != nil {
+		return err
+	}
+
+	de := deployer.NewManager(deployer.Options{
+		WorkingDir: opts.workingDir,
+		Namespace:  namespace,
+		Commit:     opts.commit,
+		Stack:      stack.GetName(),
+		Releases:   releases,
+		Router:     router,
+		Network:    stack.GetNetwork(),
+		Callers:    stack.GetCallers(),
+	})
+
+	manifests, err := renderManifests(de, stack, fns, opts.registry, opts.tag, opts.skipRoutes)
+	if err != nil {
+		return err
+	}
+	objs, err := kube.Decode(manifests)
+	if err != nil {
+		return err
+	}
+
+	var changes []*kube.Change
+	if opts.against != "" {
+		var old []*unstructured.Unstructured
+		if old, err = kube.LoadObjects(opts.against); err != nil {
+			return err
+		}
+		changes = kube.Diff(old, objs)
+	} else {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// only a whole stack can tell what was removed
+		prunable := stack.GetName()
+		if !wholeStack {
+			prunable = ""
+		}
+		if changes, err = client.DiffLive(ctx, prunable, objs); err != nil {
+			return err
+		}
+	}
+
+	if len(changes) == 0 {
+		logger.Err().Println("No changes")
+		return nil
+	}
+
+	kube.WriteChanges(logger.Out(), changes)
+	if opts.exitCode {
+		return ErrChanges
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"path"
 
+	"github.com/contextcloud/ccb/pkg/manifests"
 	"github.com/contextcloud/ccb/pkg/parser"
 	"github.com/contextcloud/ccb/pkg/print"
 	"github.com/contextcloud/ccb/pkg/templater"
@@ -15,6 +17,9 @@ import (
 type fetchOptions struct {
 	stackFile  string
 	workingDir string
+	update     bool
+	offline    bool
+	sources    []string
 }
 
 func newFetchCommand() *cobra.Command {
@@ -28,7 +33,10 @@ func newFetchCommand() *cobra.Command {
 		Long:  `fetch finds all templates and downloads them`,
 		Example: `
   ccb fetch -f https://domain/path/stack.yml
-  ccb fetch -f ./stack.yml`,
+  ccb fetch -f ./stack.yml
+  ccb fetch --update
+  ccb fetch --offline
+  ccb fetch --template-source 'acme-*=github.com/acme/templates'`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runFetch(logger, options, args)
 		},
@@ -39,11 +47,18 @@ func newFetchCommand() *cobra.Command {
 
 	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
 	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.BoolVarP(&options.update, "update", "", false, "Refresh the pinned templates in the lock file")
+	flags.BoolVarP(&options.offline, "offline", "", false, "Only use cached or vendored templates")
+	flags.StringSliceVarP(&options.sources, "template-source", "", []string{}, "Template source as a name=source pair, names can be globs")
 
 	return cmd
 }
 
 func runFetch(logger print.Logger, opts fetchOptions, args []string) error {
+	if opts.update && opts.offline {
+		return errors.New("--update can't be used with --offline")
+	}
+
 	stackFile := path.Join(opts.workingDir, opts.stackFile)
 
 	stack, err := parser.LoadStack(stackFile)
@@ -61,7 +76,31 @@ func runFetch(logger print.Logger, opts fetchOptions, args []string) error {
 		return nil
 	}
 
-	t := templater.NewTemplater(opts.workingDir)
+	sources, err := templateSources(stack, opts.sources)
+	if err != nil {
+		return err
+	}
+
+	downloaded, err := downloadTemplates(context.Background(), fns, &templater.Options{
+		WorkingDir: opts.workingDir,
+		Sources:    sources,
+		Update:     opts.update,
+		Offline:    opts.offline,
+	})
+	if err != nil {
+		logger.Err().Println("Download failed: ", err)
+		return err
+	}
+
+	for _, path := range downloaded {
+		logger.Out().Println("Fetched", path)
+	}
+	return nil
+}
+
+// downloadTemplates for the functions which aren't built from a Dockerfile
+func downloadTemplates(ctx context.Context, fns []*parser.Function, opts *templater.Options) ([]string, error) {
+	t := templater.NewTemplater(opts)
 	for _, fn := range fns {
 		if utils.IsDockerTemplate(fn.Template) {
 			continue
@@ -71,15 +110,29 @@ func runFetch(logger print.Logger, opts fetchOptions, args []string) error {
 		t.AddFunction(fn.Key, fn.Template)
 	}
 
-	ctx := context.Background()
-	downloaded, err := t.Download(ctx)
+	return t.Download(ctx)
+}
+
+// templateSources from the stack with the sources from the command line on top
+func templateSources(stack parser.Stack, overrides []string) (map[string]manifests.TemplateSource, error) {
+	parsed, err := utils.ParseMap(overrides, "template-source")
 	if err != nil {
-		logger.Err().Println("Download failed: ", err)
-		return err
+		return nil, err
 	}
 
-	for _, path := range downloaded {
-		logger.Out().Println("Fetched", path)
+	sources := make(map[string]manifests.TemplateSource)
+	if stack != nil {
+		for name, src := range stack.GetTemplateSources() {
+			sources[name] = src
+		}
 	}
-	return nil
+	for name, src := range parsed {
+		if src == nil {
+			continue
+		}
+		ts := sources[name]
+		ts.Source = *src
+		sources[name] = ts
+	}
+	return sources, nil
 }
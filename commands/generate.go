@@ -17,6 +17,8 @@ type generateOptions struct {
 	registry   string
 	namespace  string
 	commit     string
+	router     string
+	issuer     string
 	output     string
 }
 
@@ -46,6 +48,8 @@ func newGenerateCommand() *cobra.Command {
 	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker containers")
 	flags.StringVarP(&options.namespace, "namespace", "n", "", "The network to connect to")
 	flags.StringVarP(&options.commit, "commit", "", "", "The commit label")
+	flags.StringVarP(&options.router, "router", "", "", "The router to render the routes for, nginx, gateway-api or ingress, defaults to the one of the stack")
+	flags.StringVarP(&options.issuer, "issuer", "", "", "The cert-manager issuer of the certificates, defaults to the one of the stack")
 	flags.StringVarP(&options.output, "output", "o", "", "Where to save the files")
 
 	return cmd
@@ -69,7 +73,30 @@ func runGenerate(logger print.Logger, opts generateOptions, args []string) error
 		return nil
 	}
 
-	de := deployer.NewManager(opts.workingDir, opts.namespace, opts.commit)
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	if err := checkRoutes(logger, stack); err != nil {
+		return err
+	}
+
+	router, err := stackRouter(stack, opts.router, opts.issuer)
+	if err != nil {
+		return err
+	}
+
+	de := deployer.NewManager(deployer.Options{
+		WorkingDir: opts.workingDir,
+		Namespace:  opts.namespace,
+		Commit:     opts.commit,
+		Stack:      stack.GetName(),
+		Releases:   releases,
+		Router:     router,
+		Network:    stack.GetNetwork(),
+		Callers:    stack.GetCallers(),
+	})
 
 	manifests, err := de.GenerateFunctions(opts.registry, opts.tag, fns)
 	if err != nil {
@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/kube"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+
+	"github.com/spf13/cobra"
+)
+
+type historyOptions struct {
+	stackFile  string
+	workingDir string
+
+	namespace   string
+	kubeconfig  string
+	kubecontext string
+}
+
+type rollbackOptions struct {
+	historyOptions
+
+	revision int64
+	wait     bool
+	timeout  time.Duration
+}
+
+func newHistoryCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := historyOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `history <function>`,
+		Short: "history lists the revisions of a function",
+		Long:  `history lists the revisions Kubernetes kept of a deployed function with their commit and image`,
+		Example: `
+  ccb history api
+  ccb history api -n dev --context staging`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(logger, options, args[0])
+		},
+	}
+
+	addHistoryFlags(cmd, &options)
+	return cmd
+}
+
+func newRollbackCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := rollbackOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `rollback <function>`,
+		Short: "rollback a function to an earlier revision",
+		Long:  `rollback applies the pod template of an earlier revision of a deployed function, the one before the current by default`,
+		Example: `
+  ccb rollback api
+  ccb rollback api --to-revision 3 -n dev`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRollback(logger, options, args[0])
+		},
+	}
+
+	addHistoryFlags(cmd, &options.historyOptions)
+
+	flags := cmd.Flags()
+	flags.Int64VarP(&options.revision, "to-revision", "", 0, "The revision to roll back to, 0 is the one before the current")
+	flags.BoolVarP(&options.wait, "wait", "", true, "Wait for the deployment to roll out")
+	flags.DurationVarP(&options.timeout, "timeout", "", 5*time.Minute, "How long to wait for the rollout")
+
+	return cmd
+}
+
+func addHistoryFlags(cmd *cobra.Command, options *historyOptions) {
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.namespace, "namespace", "n", "", "The namespace, defaults to the one of the context")
+	flags.StringVarP(&options.kubeconfig, "kubeconfig", "", "", "Path to the kubeconfig file")
+	flags.StringVarP(&options.kubecontext, "context", "", "", "The kubeconfig context to use")
+}
+
+// client for the deployment of a function in the stack
+func (opts historyOptions) client(logger print.Logger, key string) (*kube.Client, error) {
+	stack, err := parser.LoadStack(path.Join(opts.workingDir, opts.stackFile))
+	if err != nil {
+		return nil, err
+	}
+
+	fns, err := stack.GetFunctions(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(fns) != 1 {
+		return nil, fmt.Errorf("function %s not found", key)
+	}
+
+	return kube.NewClient(logger.Out(), opts.kubeconfig, opts.kubecontext, opts.namespace)
+}
+
+func runHistory(logger print.Logger, opts historyOptions, key string) error {
+	client, err := opts.client(logger, key)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	revisions, err := client.History(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		logger.Err().Printf("No revisions of %s in %s\n", key, client.Namespace)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tCOMMIT\tIMAGE\tCREATED\tREADY\t")
+	for _, r := range revisions {
+		current := ""
+		if r.Current {
+			current = "(current)"
+		}
+		commit := r.Commit
+		if commit == "" {
+			commit = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\n",
+			r.Number,
+			shortSha(commit),
+			strings.Join(r.Images, ","),
+			r.Created.Local().Format(time.RFC3339),
+			r.Ready,
+			current,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	logger.Out().Print(buf.String())
+	return nil
+}
+
+func runRollback(logger print.Logger, opts rollbackOptions, key string) error {
+	client, err := opts.client(logger, key)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := client.Rollback(ctx, key, opts.revision); err != nil {
+		return err
+	}
+
+	if !opts.wait {
+		return nil
+	}
+	return client.WaitRollout(ctx, client.Namespace, key, opts.timeout)
+}
@@ -0,0 +1,249 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/invoke"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/runner"
+
+	"github.com/spf13/cobra"
+)
+
+type invokeOptions struct {
+	stackFile  string
+	workingDir string
+
+	path    string
+	method  string
+	headers []string
+	data    string
+	route   string
+	remote  bool
+	include bool
+	timeout time.Duration
+
+	repeat      int
+	concurrency int
+}
+
+func newInvokeCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := invokeOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `invoke <function>`,
+		Short: "invoke sends a request to a function",
+		Long:  `invoke sends a request to a function running locally, or through its route when it isn't`,
+		Example: `
+  ccb invoke api --path /api/profile
+  ccb invoke api -X PUT --path /api/profile --data @profile.json -H "Authorization: Bearer $TOKEN"
+  ccb invoke api --remote --repeat 200 --concurrency 10`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInvoke(logger, options, args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.path, "path", "", "", "The path to request, defaults to the prefix of the route")
+	flags.StringVarP(&options.method, "method", "X", "", "The method, GET or POST when there's data")
+	flags.StringArrayVarP(&options.headers, "header", "H", []string{}, "A header as Name: value")
+	flags.StringVarP(&options.data, "data", "", "", "The body, @file reads a file and @- stdin")
+	flags.StringVarP(&options.route, "route", "", "", "The name of the route to use when there are many")
+	flags.BoolVarP(&options.remote, "remote", "", false, "Use the route even when the function runs locally")
+	flags.BoolVarP(&options.include, "include", "i", false, "Print the response headers")
+	flags.DurationVarP(&options.timeout, "timeout", "", 30*time.Second, "The timeout of each request")
+	flags.IntVarP(&options.repeat, "repeat", "n", 1, "How many requests to send, more than one prints stats instead of the response")
+	flags.IntVarP(&options.concurrency, "concurrency", "c", 1, "How many requests are in flight when repeating")
+
+	return cmd
+}
+
+func runInvoke(logger print.Logger, opts invokeOptions, key string) error {
+	if opts.repeat < 1 {
+		return errors.New("--repeat must be at least 1")
+	}
+
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	fns, err := stack.GetFunctions(key)
+	if err != nil {
+		return err
+	}
+	if len(fns) != 1 {
+		return fmt.Errorf("function %s not found", key)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	base, prefix, err := functionURL(ctx, stackFile, fns[0], opts.route, opts.remote)
+	if err != nil {
+		return err
+	}
+
+	p := opts.path
+	if p == "" {
+		p = prefix
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	header, err := invoke.ParseHeaders(opts.headers)
+	if err != nil {
+		return err
+	}
+
+	req := &invoke.Request{
+		Method: strings.ToUpper(opts.method),
+		URL:    base + p,
+		Header: header,
+	}
+	if opts.data != "" {
+		if req.Body, err = invoke.ReadData(opts.data, os.Stdin); err != nil {
+			return err
+		}
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+		if req.Body != nil {
+			req.Method = http.MethodPost
+		}
+	}
+
+	client := &http.Client{Timeout: opts.timeout}
+
+	if opts.repeat > 1 {
+		logger.Err().Printf("%s %s x%d (%d concurrent)\n", req.Method, req.URL, opts.repeat, opts.concurrency)
+		printStats(logger.Out(), invoke.Load(ctx, client, req, opts.repeat, opts.concurrency))
+		return nil
+	}
+
+	resp, err := invoke.Do(ctx, client, req)
+	if err != nil {
+		return err
+	}
+
+	logger.Err().Printf("%s %s -> %s in %s\n", req.Method, req.URL, resp.Status, resp.Duration.Round(time.Millisecond))
+	if opts.include {
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range resp.Header[name] {
+				logger.Out().Printf("%s: %s\n", name, v)
+			}
+		}
+		logger.Out().Println()
+	}
+
+	_, err = logger.Out().Write(invoke.Pretty(resp.Body))
+	return err
+}
+
+// functionURL is where a function can be reached and the path to use when none
+// is given. A local container wins over the routes unless remote is set.
+func functionURL(ctx context.Context, stackFile string, fn *parser.Function, routeName string, remote bool) (string, string, error) {
+	var localErr error
+	if !remote {
+		port, err := localPort(ctx, stackFile, fn.Key)
+		if err == nil && port > 0 {
+			return fmt.Sprintf("http://127.0.0.1:%d", port), "/", nil
+		}
+		localErr = err
+	}
+
+	for _, r := range fn.Routes {
+		if r.Redirect != "" || r.FQDN == "" {
+			continue
+		}
+		if routeName != "" && r.Name != routeName {
+			continue
+		}
+		return "https://" + r.FQDN, r.Prefix, nil
+	}
+
+	switch {
+	case routeName != "":
+		return "", "", fmt.Errorf("function %s has no route %s", fn.Key, routeName)
+	case localErr != nil:
+		return "", "", fmt.Errorf("function %s has no route and docker isn't available: %w", fn.Key, localErr)
+	case remote:
+		return "", "", fmt.Errorf("function %s has no route", fn.Key)
+	}
+	return "", "", fmt.Errorf("function %s isn't running locally and has no route, try ccb run %s", fn.Key, fn.Key)
+}
+
+// localPort is the http port of the function when ccb run or ccb up started it
+func localPort(ctx context.Context, stackFile string, key string) (int, error) {
+	absStack, err := filepath.Abs(stackFile)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := runner.NewRunner(print.NewLog(os.Stderr))
+	if err != nil {
+		return 0, err
+	}
+
+	found, err := r.Find(ctx, map[string]string{
+		runner.LabelFunction: key,
+		runner.LabelStack:    absStack,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range found {
+		if c.HTTPPort > 0 {
+			return c.HTTPPort, nil
+		}
+	}
+	return 0, nil
+}
+
+func printStats(log print.Log, stats *invoke.Stats) {
+	log.Printf("Requests:  %d in %s (%.1f/s)\n", stats.Requests, stats.Total.Round(time.Millisecond), stats.Rate())
+	if stats.Errors > 0 {
+		log.Printf("Errors:    %d\n", stats.Errors)
+	}
+
+	codes := make([]int, 0, len(stats.Codes))
+	for code := range stats.Codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		log.Printf("Status %d: %d\n", code, stats.Codes[code])
+	}
+
+	round := func(d time.Duration) time.Duration {
+		return d.Round(10 * time.Microsecond)
+	}
+	log.Printf("Latency:   mean %s, p50 %s, p95 %s, p99 %s, max %s\n",
+		round(stats.Mean()), round(stats.Percentile(50)), round(stats.Percentile(95)), round(stats.Percentile(99)), round(stats.Percentile(100)))
+}
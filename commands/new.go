@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/templater"
+	"github.com/contextcloud/ccb/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// function keys become directories and kubernetes names
+var functionKeyRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+type newOptions struct {
+	stackFile  string
+	workingDir string
+	template   string
+	sources    []string
+}
+
+func newNewCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := newOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `new <key>`,
+		Short: "new creates a function from a template",
+		Long:  `new fetches a template, copies its starter files into a new function and adds it to the stack`,
+		Example: `
+  ccb new api --template golang
+  ccb new api --template golang@v1.4.0 -f ./stack.yml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNew(logger, options, args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.template, "template", "", "", "The template to start the function from")
+	flags.StringSliceVarP(&options.sources, "template-source", "", []string{}, "Template source as a name=source pair, names can be globs")
+
+	_ = cmd.MarkFlagRequired("template")
+
+	return cmd
+}
+
+func runNew(logger print.Logger, opts newOptions, key string) error {
+	if !functionKeyRegex.MatchString(key) {
+		return fmt.Errorf("invalid function name %q, use lowercase letters, digits and dashes", key)
+	}
+	if utils.IsDockerTemplate(opts.template) {
+		return errors.New("--template must be a ccb template, dockerfile functions have no starter files")
+	}
+
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	existing, err := stack.GetFunctions(key)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%w: %s", parser.ErrFunctionExists, key)
+	}
+
+	sources, err := templateSources(stack, opts.sources)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	t := templater.NewTemplater(&templater.Options{
+		WorkingDir: opts.workingDir,
+		Sources:    sources,
+	})
+	t.AddFunction(key, opts.template)
+	if _, err := t.Download(ctx); err != nil {
+		return err
+	}
+
+	dir, err := templater.FindTemplate(opts.workingDir, opts.template)
+	if err != nil {
+		return err
+	}
+
+	dst := path.Join(opts.workingDir, key)
+	if err := templater.Scaffold(ctx, dir, dst); err != nil {
+		return err
+	}
+
+	if err := parser.AddFunction(stackFile, key, opts.template); err != nil {
+		return err
+	}
+
+	logger.Out().Printf("Created %s from %s, added it to %s\n", dst, opts.template, stackFile)
+	return nil
+}
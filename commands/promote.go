@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+
+	"github.com/spf13/cobra"
+)
+
+type promoteOptions struct {
+	stackFile  string
+	workingDir string
+
+	to     string
+	weight int
+}
+
+func newPromoteCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := promoteOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `promote <release>`,
+		Short: "promote shifts the traffic of a release",
+		Long:  `promote moves the weight of a function in a release to its next step and writes it to the stack file, deploy to apply it`,
+		Example: `
+  ccb promote assets
+  ccb promote assets --to assets_2 --weight 100
+  ccb promote assets --to assets_1 --weight 100`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPromote(logger, options, args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringVarP(&options.to, "to", "", "", "The function to promote, defaults to the last split")
+	flags.IntVarP(&options.weight, "weight", "", -1, "The weight to give it, defaults to the next step")
+
+	return cmd
+}
+
+func runPromote(logger print.Logger, opts promoteOptions, key string) error {
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	releases, err := stack.GetReleases(key)
+	if err != nil {
+		return err
+	}
+	if len(releases) != 1 {
+		return fmt.Errorf("release %s not found", key)
+	}
+	release := releases[0]
+
+	to := opts.to
+	if to == "" {
+		to = release.Splits[len(release.Splits)-1].Function
+	}
+
+	weight := opts.weight
+	if weight < 0 {
+		if weight, err = release.NextStep(to); err != nil {
+			return err
+		}
+	}
+
+	splits, err := release.Promote(to, weight)
+	if err != nil {
+		return err
+	}
+	if err := parser.SetReleaseWeights(stackFile, release.Key, splits); err != nil {
+		return err
+	}
+
+	var out []string
+	for _, s := range splits {
+		out = append(out, fmt.Sprintf("%s %d%%", s.Function, s.Weight))
+	}
+	logger.Out().Printf("%s: %s\n", release.Key, strings.Join(out, ", "))
+	logger.Err().Println("Run ccb deploy to shift the traffic")
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 const defaultStackFile = "stack.yml"
 const defaultWorkingDir = "."
+const defaultPolicyFile = ".ccb/policy.yaml"
 const rootCommandName = "ccb"
 
 // These variables are initialized externally during the build. See the Makefile.
@@ -38,9 +39,22 @@ func NewRootCommand() *cobra.Command {
 	}
 
 	cmd.AddCommand(newBuildCommand())
+	cmd.AddCommand(newCacheCommand())
+	cmd.AddCommand(newDeployCommand())
+	cmd.AddCommand(newDiffCommand())
+	cmd.AddCommand(newDevCommand())
 	cmd.AddCommand(newFetchCommand())
 	cmd.AddCommand(newGenerateCommand())
+	cmd.AddCommand(newHistoryCommand())
+	cmd.AddCommand(newInvokeCommand())
+	cmd.AddCommand(newNewCommand())
+	cmd.AddCommand(newPromoteCommand())
+	cmd.AddCommand(newRollbackCommand())
 	cmd.AddCommand(newRoutesCommand())
+	cmd.AddCommand(newRunCommand())
+	cmd.AddCommand(newTemplateCommand())
+	cmd.AddCommand(newUpCommand())
+	cmd.AddCommand(newVendorCommand())
 	cmd.AddCommand(newVersionCommand())
 
 	return cmd
@@ -1,11 +1,16 @@
 package commands
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"path"
+	"text/tabwriter"
 
 	"github.com/contextcloud/ccb/pkg/deployer"
 	"github.com/contextcloud/ccb/pkg/parser"
 	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/routing"
 
 	"github.com/spf13/cobra"
 )
@@ -15,7 +20,10 @@ type routesOptions struct {
 	workingDir string
 	namespace  string
 	commit     string
+	router     string
+	issuer     string
 	output     string
+	table      bool
 }
 
 func newRoutesCommand() *cobra.Command {
@@ -29,7 +37,8 @@ func newRoutesCommand() *cobra.Command {
 		Long:  `generates http proxy routes Manifest files using a spec provided in yaml`,
 		Example: `
 		ccb routes -f https://domain/path/stack.yml
-		ccb routes -f ./stack.yml`,
+		ccb routes -f ./stack.yml
+		ccb routes --table`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runRoutes(logger, options, args)
 		},
@@ -42,7 +51,10 @@ func newRoutesCommand() *cobra.Command {
 	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
 	flags.StringVarP(&options.namespace, "namespace", "n", "", "The network to connect to")
 	flags.StringVarP(&options.commit, "commit", "", "", "The commit label")
+	flags.StringVarP(&options.router, "router", "", "", "The router to render the routes for, nginx, gateway-api or ingress, defaults to the one of the stack")
+	flags.StringVarP(&options.issuer, "issuer", "", "", "The cert-manager issuer of the certificates, defaults to the one of the stack")
 	flags.StringVarP(&options.output, "output", "o", "", "Where to save the files")
+	flags.BoolVarP(&options.table, "table", "", false, "Print the routing table and its problems instead of the manifests")
 
 	return cmd
 }
@@ -60,12 +72,31 @@ func runRoutes(logger print.Logger, opts routesOptions, args []string) error {
 		return err
 	}
 
+	if opts.table {
+		return printRouteTable(logger, stack, routes)
+	}
+
 	if len(routes) == 0 {
 		logger.Err().Println("No routes found")
 		return nil
 	}
 
-	de := deployer.NewManager(opts.workingDir, opts.namespace, opts.commit)
+	if err := checkRoutes(logger, stack); err != nil {
+		return err
+	}
+
+	router, err := stackRouter(stack, opts.router, opts.issuer)
+	if err != nil {
+		return err
+	}
+
+	de := deployer.NewManager(deployer.Options{
+		WorkingDir: opts.workingDir,
+		Namespace:  opts.namespace,
+		Commit:     opts.commit,
+		Stack:      stack.GetName(),
+		Router:     router,
+	})
 
 	manifests, err := de.GenerateRoutes(routes)
 	if err != nil {
@@ -79,3 +110,73 @@ func runRoutes(logger print.Logger, opts routesOptions, args []string) error {
 	manifests.Print(logger.Out())
 	return nil
 }
+
+// checkRoutes of the whole stack, warnings are printed and errors returned
+func checkRoutes(logger print.Logger, stack parser.Stack) error {
+	routes, err := stack.GetRoutes()
+	if err != nil {
+		return err
+	}
+	fns, err := stack.GetFunctions()
+	if err != nil {
+		return err
+	}
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	return reportProblems(logger, routing.Analyze(routes, fns, releases))
+}
+
+func reportProblems(logger print.Logger, problems []*routing.Problem) error {
+	var errs []error
+	for _, p := range problems {
+		if p.Warning {
+			logger.Err().Printf("Warning: %s\n", p)
+			continue
+		}
+		errs = append(errs, p)
+	}
+	return errors.Join(errs...)
+}
+
+// printRouteTable in the order requests are matched, the longest prefix of a
+// host first
+func printRouteTable(logger print.Logger, stack parser.Stack, routes []*parser.Route) error {
+	fns, err := stack.GetFunctions()
+	if err != nil {
+		return err
+	}
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tPREFIX\tMATCH\tTARGET\tROUTE\tREWRITE")
+	for _, rt := range routing.BuildTable(routes, fns, releases) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			rt.Host,
+			rt.Prefix,
+			orDash(rt.Conditions()),
+			rt.Target(),
+			orDash(rt.Name),
+			orDash(rt.Rewrite),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	logger.Out().Print(buf.String())
+
+	return reportProblems(logger, routing.Analyze(routes, fns, releases))
+}
+
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/runner"
+	"github.com/contextcloud/ccb/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+type runOptions struct {
+	stackFile  string
+	workingDir string
+	buildArgs  []string
+
+	tag      string
+	registry string
+	prefix   string
+
+	build   bool
+	network string
+	port    int
+	timeout time.Duration
+	detach  bool
+}
+
+func newRunCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := runOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `run <function>`,
+		Short: "run starts a function locally",
+		Long:  `run starts a function in docker with the environment and secrets it's deployed with`,
+		Example: `
+  ccb run api
+  ccb run api --build --port 9080
+  ccb run api --detach`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRun(logger, options, args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringSliceVarP(&options.buildArgs, "build-args", "", []string{}, "To be parsed as a key=value pair to docker build")
+	flags.StringVarP(&options.tag, "tag", "t", "latest", "The tag for the containers")
+	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker images")
+	flags.StringVarP(&options.prefix, "prefix", "", "", "The prefix for the docker image name")
+	flags.BoolVarP(&options.build, "build", "", false, "Build the image even when it exists")
+	flags.StringVarP(&options.network, "network", "", "", "The network to connect to")
+	flags.IntVarP(&options.port, "port", "p", runner.PortHTTP, "The host port for http, metrics and health use the next two")
+	flags.DurationVarP(&options.timeout, "timeout", "", time.Minute, "How long to wait for the probes to pass")
+	flags.BoolVarP(&options.detach, "detach", "", false, "Leave the function running in the background")
+
+	return cmd
+}
+
+func runRun(logger print.Logger, opts runOptions, key string) error {
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	fns, err := stack.GetFunctions(key)
+	if err != nil {
+		return err
+	}
+	if len(fns) != 1 {
+		return fmt.Errorf("function %s not found", key)
+	}
+	fn := fns[0]
+
+	runOpts, err := functionRunOptions(logger, stackFile, opts.workingDir, fn)
+	if err != nil {
+		return err
+	}
+
+	r, err := runner.NewRunner(logger.Out())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	images, err := ensureImages(ctx, logger, r, opts, fns)
+	if err != nil {
+		return err
+	}
+
+	runOpts.Image = images[fn.Key]
+	runOpts.Network = opts.network
+	runOpts.HTTPPort = opts.port
+	runOpts.MetricsPort = opts.port + 1
+	runOpts.HealthPort = opts.port + 2
+
+	c, err := r.Start(ctx, runOpts)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Wait(ctx, c, opts.timeout); err != nil {
+		_ = r.Stop(context.Background(), c)
+		return err
+	}
+	logger.Out().Printf("%s is up on http://localhost:%d (metrics %d, health %d)\n", fn.Key, c.HTTPPort, c.MetricsPort, c.HealthPort)
+
+	if opts.detach {
+		return nil
+	}
+
+	// stream the logs until interrupted, then clean up
+	if err := r.Logs(ctx, c, logger.Out(), true); err != nil {
+		logger.Err().Println("Logs failed: ", err)
+	}
+	logger.Out().Printf("%s: Stopping\n", fn.Key)
+	return r.Stop(context.Background(), c)
+}
+
+// functionRunOptions has the environment and secrets a function is deployed with,
+// the container is labelled with the stack so it can be found again
+func functionRunOptions(logger print.Logger, stackFile string, workingDir string, fn *parser.Function) (*runner.Options, error) {
+	absStack, err := filepath.Abs(stackFile)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := deployer.FunctionEnvironment(workingDir, fn)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := functionSecrets(logger, workingDir, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runner.Options{
+		Name:    fn.Key,
+		Env:     env,
+		Secrets: secrets,
+		Labels:  map[string]string{runner.LabelStack: absStack},
+	}, nil
+}
+
+// buildOptions to build the images of local functions
+func (opts runOptions) buildOptions() buildOptions {
+	return buildOptions{
+		stackFile:  opts.stackFile,
+		workingDir: opts.workingDir,
+		network:    opts.network,
+		buildArgs:  opts.buildArgs,
+		tag:        opts.tag,
+		registry:   opts.registry,
+		prefix:     opts.prefix,
+		policyFile: defaultPolicyFile,
+		poolSize:   1,
+	}
+}
+
+// ensureImages builds the images of the functions that are missing, or all of
+// them with --build, and returns the image of each function.
+func ensureImages(ctx context.Context, logger print.Logger, r runner.Runner, opts runOptions, fns []*parser.Function) (map[string]string, error) {
+	images := make(map[string]string)
+	var missing []string
+	for _, fn := range fns {
+		image := deployer.ImageName(opts.registry, opts.prefix+fn.Key, opts.tag)
+		images[fn.Key] = image
+
+		exists, err := r.ImageExists(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || opts.build {
+			missing = append(missing, fn.Key)
+		}
+	}
+
+	if len(missing) == 0 {
+		return images, nil
+	}
+
+	return images, runBuild(logger, opts.buildOptions(), os.Stdin, missing)
+}
+
+// functionSecrets are the values of the plain secrets of a function, sops
+// secrets are encrypted and skipped.
+func functionSecrets(logger print.Logger, workingDir string, fn *parser.Function) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, secret := range fn.Secrets {
+		filename, err := utils.YamlFile(workingDir, secret)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := deployer.LoadSecretData(filename)
+		if errors.Is(err, deployer.ErrEncryptedSecret) {
+			logger.Err().Printf("%s: Skipping encrypted secret %s\n", fn.Key, secret)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = utils.MergeMap(out, data)
+	}
+	return out, nil
+}
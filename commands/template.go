@@ -0,0 +1,202 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/templater"
+
+	"github.com/spf13/cobra"
+)
+
+type templateInspectOptions struct {
+	workingDir string
+}
+
+type templateListOptions struct {
+	stackFile  string
+	workingDir string
+	sources    []string
+	refresh    bool
+	offline    bool
+}
+
+func newTemplateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   `template`,
+		Short: "template shows the templates functions are built from",
+		Long:  `template shows the templates functions are built from`,
+		Example: `
+  ccb template list
+  ccb template list --refresh
+  ccb template inspect golang
+  ccb template inspect golang@v1.4.0`,
+	}
+
+	cmd.AddCommand(newTemplateListCommand())
+	cmd.AddCommand(newTemplateInspectCommand())
+
+	return cmd
+}
+
+func newTemplateListCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := templateListOptions{}
+
+	cmd := &cobra.Command{
+		Use:     `list`,
+		Aliases: []string{"ls"},
+		Short:   "list shows the templates in every configured source",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTemplateList(logger, options)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringSliceVarP(&options.sources, "template-source", "", []string{}, "Template source as a name=source pair, names can be globs")
+	flags.BoolVarP(&options.refresh, "refresh", "", false, "List the sources again instead of using the cached index")
+	flags.BoolVarP(&options.offline, "offline", "", false, "Only use the cached index")
+
+	return cmd
+}
+
+func newTemplateInspectCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := templateInspectOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `inspect <name>`,
+		Short: "inspect prints the metadata of a fetched template",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTemplateInspect(logger, options, args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+
+	return cmd
+}
+
+func runTemplateList(logger print.Logger, opts templateListOptions) error {
+	if opts.refresh && opts.offline {
+		return errors.New("--refresh can't be used with --offline")
+	}
+
+	// the stack is optional, templates can be listed before there is one
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+	var stack parser.Stack
+	if _, err := os.Stat(stackFile); err == nil {
+		s, err := parser.LoadStack(stackFile)
+		if err != nil {
+			return err
+		}
+		stack = s
+	}
+
+	sources, err := templateSources(stack, opts.sources)
+	if err != nil {
+		return err
+	}
+
+	t := templater.NewTemplater(&templater.Options{
+		WorkingDir: opts.workingDir,
+		Sources:    sources,
+		Offline:    opts.offline,
+	})
+	indexes, err := t.List(context.Background(), opts.refresh)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for i, idx := range indexes {
+		if i > 0 {
+			fmt.Fprintln(&buf)
+		}
+		fmt.Fprintln(&buf, idx.Source)
+		if len(idx.Versions) > 0 {
+			fmt.Fprintf(&buf, "Versions: %s\n", strings.Join(idx.Versions, ", "))
+		}
+
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tLANGUAGE\tFETCHED\tDESCRIPTION")
+		for _, tmpl := range idx.Templates {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", tmpl.Name, tmpl.Language, strings.Join(tmpl.Fetched, ", "), tmpl.Description)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	logger.Out().Print(buf.String())
+	return nil
+}
+
+func runTemplateInspect(logger print.Logger, opts templateInspectOptions, template string) error {
+	dir, err := templater.FindTemplate(opts.workingDir, template)
+	if err != nil {
+		return fmt.Errorf("%w, run ccb fetch first", err)
+	}
+
+	meta, err := templater.LoadMetadata(dir)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		logger.Err().Printf("%s has no %s\n", template, templater.MetadataFile)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", meta.Name)
+	fmt.Fprintf(w, "Description:\t%s\n", meta.Description)
+	fmt.Fprintf(w, "Language:\t%s\n", meta.Language)
+	fmt.Fprintf(w, "Path:\t%s\n", dir)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(meta.BuildArgs) > 0 {
+		fmt.Fprintln(&buf, "\nBuild args:")
+		w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tDEFAULT\tREQUIRED\tALLOWED\tDESCRIPTION")
+		for _, arg := range meta.BuildArgs {
+			allowed := arg.Pattern
+			if len(arg.Values) > 0 {
+				allowed = strings.Join(arg.Values, "|")
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%t\t%s\t%s\n", arg.Name, arg.Default, arg.Required, allowed, arg.Description)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(meta.RequiredFiles) > 0 {
+		fmt.Fprintln(&buf, "\nRequired files:")
+		for _, file := range meta.RequiredFiles {
+			fmt.Fprintf(&buf, "  %s\n", file)
+		}
+	}
+
+	logger.Out().Print(buf.String())
+	return nil
+}
@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/routing"
+	"github.com/contextcloud/ccb/pkg/runner"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultUpNetwork = "ccb"
+const defaultProxyPort = 8000
+
+type upOptions struct {
+	runOptions
+
+	proxyPort int
+}
+
+func newUpCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := upOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `up [filters...]`,
+		Short: "up runs the stack locally",
+		Long:  `up runs the functions of the stack in docker on a shared network behind a proxy that routes like the cluster does`,
+		Example: `
+  ccb up
+  ccb up "api*" --port 9000
+  ccb up --build`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUp(logger, options, args, nil)
+		},
+	}
+
+	addUpFlags(cmd, &options)
+	return cmd
+}
+
+func addUpFlags(cmd *cobra.Command, options *upOptions) {
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.StringSliceVarP(&options.buildArgs, "build-args", "", []string{}, "To be parsed as a key=value pair to docker build")
+	flags.StringVarP(&options.tag, "tag", "t", "latest", "The tag for the containers")
+	flags.StringVarP(&options.registry, "registry", "", "", "The registry for the docker images")
+	flags.StringVarP(&options.prefix, "prefix", "", "", "The prefix for the docker image name")
+	flags.BoolVarP(&options.build, "build", "", false, "Build the images even when they exist")
+	flags.StringVarP(&options.network, "network", "", defaultUpNetwork, "The network the functions share")
+	flags.IntVarP(&options.proxyPort, "port", "p", defaultProxyPort, "The host port of the proxy")
+	flags.DurationVarP(&options.timeout, "timeout", "", time.Minute, "How long to wait for the probes to pass")
+}
+
+// runUp runs the stack until interrupted, watch runs alongside the proxy when set
+func runUp(logger print.Logger, opts upOptions, filters []string, watch func(ctx context.Context, s *localStack) error) error {
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	fns, err := stack.GetFunctions(filters...)
+	if err != nil {
+		return err
+	}
+	if len(fns) == 0 {
+		return errors.New("no functions to run")
+	}
+	routes, err := stack.GetRoutes()
+	if err != nil {
+		return err
+	}
+	releases, err := stack.GetReleases()
+	if err != nil {
+		return err
+	}
+
+	s, err := newLocalStack(logger, opts.runOptions, stackFile, fns)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := s.runner.EnsureNetwork(ctx, opts.network); err != nil {
+		return fmt.Errorf("unable to create network %s: %w", opts.network, err)
+	}
+
+	images, err := ensureImages(ctx, logger, s.runner, opts.runOptions, fns)
+	if err != nil {
+		return err
+	}
+
+	if err := s.start(ctx, images); err != nil {
+		s.stop()
+		return err
+	}
+
+	upstreams := s.upstreams()
+	proxy, err := routing.NewProxy(logger.Out(), routing.BuildTable(routes, fns, releases), upstreams)
+	if err != nil {
+		s.stop()
+		return err
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.proxyPort))
+	if err != nil {
+		s.stop()
+		return err
+	}
+	srv := &http.Server{Handler: proxy}
+
+	for _, fn := range fns {
+		logger.Out().Printf("%s is up on %s\n", fn.Key, upstreams[fn.Key])
+	}
+	logger.Out().Printf("Proxy is up on http://localhost:%d\n", opts.proxyPort)
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	if watch != nil {
+		go func() {
+			if err := watch(ctx, s); err != nil {
+				logger.Err().Println("Watch failed: ", err)
+				stop()
+			}
+		}()
+	}
+
+	err = srv.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	// the proxy failing takes the logs down with it
+	stop()
+	s.logs.Wait()
+	logger.Out().Println("Stopping")
+	s.stop()
+	return err
+}
+
+// localStack is the functions of a stack running in docker
+type localStack struct {
+	logger print.Logger
+	runner runner.Runner
+	opts   runOptions
+
+	keys    []string
+	runOpts map[string]*runner.Options
+
+	mu         sync.Mutex
+	containers map[string]*runner.Container
+	logs       sync.WaitGroup
+}
+
+// newLocalStack resolves the environment of every function before docker gets involved
+func newLocalStack(logger print.Logger, opts runOptions, stackFile string, fns []*parser.Function) (*localStack, error) {
+	s := &localStack{
+		logger:     logger,
+		opts:       opts,
+		runOpts:    make(map[string]*runner.Options),
+		containers: make(map[string]*runner.Container),
+	}
+	for _, fn := range fns {
+		o, err := functionRunOptions(logger, stackFile, opts.workingDir, fn)
+		if err != nil {
+			return nil, err
+		}
+		o.Network = opts.network
+		o.Aliases = []string{fn.Key}
+
+		s.keys = append(s.keys, fn.Key)
+		s.runOpts[fn.Key] = o
+	}
+
+	r, err := runner.NewRunner(logger.Out())
+	if err != nil {
+		return nil, err
+	}
+	s.runner = r
+	return s, nil
+}
+
+// start every function and wait for all of them to be ready
+func (s *localStack) start(ctx context.Context, images map[string]string) error {
+	var started []*runner.Container
+	for _, key := range s.keys {
+		o := s.runOpts[key]
+		o.Image = images[key]
+
+		c, err := s.runner.Start(ctx, o)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		s.set(key, c)
+		started = append(started, c)
+	}
+
+	for _, c := range started {
+		if err := s.runner.Wait(ctx, c, s.opts.timeout); err != nil {
+			return err
+		}
+	}
+	for i, c := range started {
+		s.follow(ctx, s.keys[i], c)
+	}
+	return nil
+}
+
+// restart a function with its new image, the host ports are kept so the proxy
+// doesn't need to know
+func (s *localStack) restart(ctx context.Context, key string) error {
+	s.mu.Lock()
+	old, ok := s.containers[key]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("function %s isn't running", key)
+	}
+
+	if err := s.runner.Stop(ctx, old); err != nil {
+		return err
+	}
+
+	o := *s.runOpts[key]
+	o.HTTPPort = old.HTTPPort
+	o.MetricsPort = old.MetricsPort
+	o.HealthPort = old.HealthPort
+
+	c, err := s.runner.Start(ctx, &o)
+	if err != nil {
+		return err
+	}
+	s.set(key, c)
+
+	if err := s.runner.Wait(ctx, c, s.opts.timeout); err != nil {
+		return err
+	}
+	s.follow(ctx, key, c)
+	return nil
+}
+
+// follow the logs of a function with its name in front
+func (s *localStack) follow(ctx context.Context, key string, c *runner.Container) {
+	s.logs.Add(1)
+	go func() {
+		defer s.logs.Done()
+		if err := s.runner.Logs(ctx, c, print.NewPrefixLog(os.Stdout, key+" | "), true); err != nil {
+			s.logger.Err().Printf("%s: Logs failed: %s\n", key, err)
+		}
+	}()
+}
+
+func (s *localStack) set(key string, c *runner.Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[key] = c
+}
+
+func (s *localStack) upstreams() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]string)
+	for key, c := range s.containers {
+		out[key] = fmt.Sprintf("http://127.0.0.1:%d", c.HTTPPort)
+	}
+	return out
+}
+
+// stop every function that was started
+func (s *localStack) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range s.containers {
+		if err := s.runner.Stop(context.Background(), c); err != nil {
+			s.logger.Err().Printf("%s: Stop failed: %s\n", key, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"path"
+
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/contextcloud/ccb/pkg/templater"
+
+	"github.com/spf13/cobra"
+)
+
+type vendorOptions struct {
+	stackFile  string
+	workingDir string
+	update     bool
+	sources    []string
+}
+
+func newVendorCommand() *cobra.Command {
+	logger := print.NewConsoleLogger()
+	options := vendorOptions{}
+
+	cmd := &cobra.Command{
+		Use:   `vendor`,
+		Short: "vendor copies all templates into the repository",
+		Long:  `vendor fetches every template the stack needs and copies it into .ccb/vendor for hermetic builds`,
+		Example: `
+  ccb vendor
+  ccb vendor -f ./stack.yml
+  ccb vendor --update`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVendor(logger, options, args)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.SortFlags = false
+
+	flags.StringVarP(&options.stackFile, "stack", "f", defaultStackFile, "Path to Stack file")
+	flags.StringVarP(&options.workingDir, "working-dir", "d", defaultWorkingDir, "Working directory")
+	flags.BoolVarP(&options.update, "update", "", false, "Refresh the pinned templates in the lock file")
+	flags.StringSliceVarP(&options.sources, "template-source", "", []string{}, "Template source as a name=source pair, names can be globs")
+
+	return cmd
+}
+
+func runVendor(logger print.Logger, opts vendorOptions, args []string) error {
+	stackFile := path.Join(opts.workingDir, opts.stackFile)
+
+	stack, err := parser.LoadStack(stackFile)
+	if err != nil {
+		return err
+	}
+
+	fns, err := stack.GetFunctions(args...)
+	if err != nil {
+		return err
+	}
+
+	if len(fns) == 0 {
+		logger.Err().Println("No functions found")
+		return nil
+	}
+
+	sources, err := templateSources(stack, opts.sources)
+	if err != nil {
+		return err
+	}
+
+	vendored, err := downloadTemplates(context.Background(), fns, &templater.Options{
+		WorkingDir: opts.workingDir,
+		Sources:    sources,
+		Update:     opts.update,
+		Vendor:     true,
+	})
+	if err != nil {
+		logger.Err().Println("Vendor failed: ", err)
+		return err
+	}
+
+	for _, template := range vendored {
+		logger.Out().Println("Vendored", template, "into", templater.VendorPath(opts.workingDir, template))
+	}
+	return nil
+}
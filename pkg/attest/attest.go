@@ -0,0 +1,184 @@
+package attest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var (
+	// ErrNoDigest when the pushed image has no digest
+	ErrNoDigest = errors.New("no digest for pushed image")
+	// ErrInvalidFormat when the sbom format isn't supported
+	ErrInvalidFormat = errors.New("unsupported sbom format")
+)
+
+const (
+	// SignatureArtifactType is the cosign artifact type for signatures attached as referrers
+	SignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// SimpleSigningMediaType is the cosign media type for the signed payload
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation holds the base64 encoded signature of the payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+type Options struct {
+	Log      print.Log
+	Keychain authn.Keychain
+	Version  string
+
+	// SignKey is the path to a PEM encoded private key
+	SignKey string
+	// SBOM is the format of the sbom, spdx or cyclonedx
+	SBOM string
+}
+
+// Attester signs pushed images and attaches their sbom.
+type Attester interface {
+	Attest(ctx context.Context, image string, digest string) error
+}
+
+// NewAttester loads the signing key and checks the sbom format up front
+// so a bad flag fails before anything is built.
+func NewAttester(opts *Options) (Attester, error) {
+	a := &attester{
+		Options: opts,
+	}
+
+	if opts.Keychain == nil {
+		a.Keychain = authn.DefaultKeychain
+	}
+
+	if opts.SignKey != "" {
+		signer, err := LoadSigner(opts.SignKey)
+		if err != nil {
+			return nil, err
+		}
+		a.signer = signer
+	}
+
+	if opts.SBOM != "" {
+		if _, ok := sbomFormats[opts.SBOM]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, opts.SBOM)
+		}
+	}
+
+	return a, nil
+}
+
+type attester struct {
+	*Options
+
+	signer *Signer
+}
+
+func (a *attester) remoteOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(a.Keychain),
+	}
+}
+
+// Attest signs the digest of image and attaches an sbom of its layers.
+func (a *attester) Attest(ctx context.Context, image string, digest string) error {
+	if digest == "" {
+		return ErrNoDigest
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	subject := ref.Context().Digest(digest)
+
+	desc, err := remote.Head(subject, a.remoteOptions(ctx)...)
+	if err != nil {
+		return err
+	}
+
+	if a.SBOM != "" {
+		a.Log.Printf("%s: Generating %s sbom\n", image, a.SBOM)
+
+		img, err := remote.Image(subject, a.remoteOptions(ctx)...)
+		if err != nil {
+			return err
+		}
+		packages, err := ScanImage(img)
+		if err != nil {
+			return err
+		}
+
+		format := sbomFormats[a.SBOM]
+		doc, err := format.encode(&Document{
+			Image:    ref.Context().Name(),
+			Digest:   digest,
+			Tool:     "ccb-" + a.Version,
+			Packages: packages,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := a.attach(ctx, subject, *desc, format.mediaType, static.NewLayer(doc, format.mediaType), nil); err != nil {
+			return err
+		}
+	}
+
+	if a.signer != nil {
+		a.Log.Printf("%s: Signing %s\n", image, digest)
+
+		payload, err := SimpleSigningPayload(ref.Context().Name(), digest)
+		if err != nil {
+			return err
+		}
+		sig, err := a.signer.Sign(payload)
+		if err != nil {
+			return err
+		}
+
+		layer := static.NewLayer(payload, SimpleSigningMediaType)
+		annotations := map[string]string{
+			SignatureAnnotation: sig,
+		}
+		if err := a.attach(ctx, subject, *desc, SignatureArtifactType, layer, annotations); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attach pushes a single layer artifact that refers to the subject.
+func (a *attester) attach(ctx context.Context, subject name.Digest, desc v1.Descriptor, artifactType types.MediaType, layer v1.Layer, annotations map[string]string) error {
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       layer,
+		Annotations: annotations,
+	})
+	if err != nil {
+		return err
+	}
+	img = mutate.MediaType(img, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, artifactType)
+	img = mutate.Subject(img, v1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}).(v1.Image)
+
+	dgst, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	return remote.Write(subject.Context().Digest(dgst.String()), img, a.remoteOptions(ctx)...)
+}
@@ -0,0 +1,197 @@
+package attest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+L:MIT
+
+C:Q1def=
+P:busybox
+V:1.36.1-r5
+L:GPL-2.0-only
+`
+
+func pushTestImage(t *testing.T, host string) (string, string) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "lib/apk/db/installed", Mode: 0644, Size: int64(len(apkInstalled))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(apkInstalled)); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := host + "/ccb/profile:latest"
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image, digest.String()
+}
+
+func writeTestKey(t *testing.T) (string, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := path.Join(t.TempDir(), "cosign.key")
+	raw := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filename, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename, key
+}
+
+func Test_Attest(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	image, digest := pushTestImage(t, host)
+	keyFile, key := writeTestKey(t)
+
+	attester, err := NewAttester(&Options{
+		Log:      print.NewLog(io.Discard),
+		Keychain: authn.NewMultiKeychain(),
+		Version:  "test",
+		SignKey:  keyFile,
+		SBOM:     "spdx",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := attester.Attest(context.Background(), image, digest); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ref, _ := name.ParseReference(image)
+	subject := ref.Context().Digest(digest)
+	idx, err := remote.Referrers(subject)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	found := make(map[string]bool)
+	for _, desc := range manifest.Manifests {
+		found[string(desc.ArtifactType)] = true
+
+		img, err := remote.Image(subject.Context().Digest(desc.Digest.String()))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		layers, err := img.Layers()
+		if err != nil || len(layers) != 1 {
+			t.Errorf("Invalid layers: %v", err)
+			return
+		}
+		rc, err := layers[0].Uncompressed()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+
+		switch desc.ArtifactType {
+		case "application/spdx+json":
+			var doc struct {
+				Packages []struct {
+					Name string `json:"name"`
+				} `json:"packages"`
+			}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				t.Error(err)
+				return
+			}
+			if len(doc.Packages) != 3 {
+				t.Errorf("Invalid sbom packages: %v", doc.Packages)
+			}
+		case SignatureArtifactType:
+			imgManifest, err := img.Manifest()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sig, err := base64.StdEncoding.DecodeString(imgManifest.Layers[0].Annotations[SignatureAnnotation])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sum := sha256.Sum256(body)
+			if !ecdsa.VerifyASN1(&key.PublicKey, sum[:], sig) {
+				t.Error("Invalid signature")
+			}
+			if !strings.Contains(string(body), digest) {
+				t.Error("Invalid payload")
+			}
+		}
+	}
+
+	if !found["application/spdx+json"] || !found[SignatureArtifactType] {
+		t.Errorf("Missing referrers: %v", found)
+	}
+}
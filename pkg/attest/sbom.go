@@ -0,0 +1,408 @@
+package attest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/uuid"
+)
+
+// maxBinarySize bounds the executables read into memory looking for go build info.
+const maxBinarySize = 256 << 20
+
+// Package found in an image.
+type Package struct {
+	Type     string
+	Name     string
+	Version  string
+	License  string
+	Location string
+}
+
+// PURL is the package url of the package.
+func (p *Package) PURL() string {
+	switch p.Type {
+	case "golang":
+		return fmt.Sprintf("pkg:golang/%s@%s", p.Name, p.Version)
+	default:
+		return fmt.Sprintf("pkg:%s/%s@%s", p.Type, p.Name, p.Version)
+	}
+}
+
+// Document describes an image and its packages.
+type Document struct {
+	Image    string
+	Digest   string
+	Tool     string
+	Packages []*Package
+}
+
+type sbomFormat struct {
+	mediaType types.MediaType
+	encode    func(doc *Document) ([]byte, error)
+}
+
+var sbomFormats = map[string]sbomFormat{
+	"spdx": {
+		mediaType: "application/spdx+json",
+		encode:    encodeSPDX,
+	},
+	"cyclonedx": {
+		mediaType: "application/vnd.cyclonedx+json",
+		encode:    encodeCycloneDX,
+	},
+}
+
+// ScanImage finds the apk, dpkg and go module packages in the layers of img.
+func ScanImage(img v1.Image) ([]*Package, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	// later layers override or delete what earlier ones found
+	found := make(map[string][]*Package)
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+		err = scanLayer(rc, found)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []*Package
+	for _, pkgs := range found {
+		out = append(out, pkgs...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Location < out[j].Location
+	})
+	return out, nil
+}
+
+func scanLayer(rd io.Reader, found map[string][]*Package) error {
+	tr := tar.NewReader(rd)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p := path.Clean("/" + h.Name)
+		dir, base := path.Split(p)
+		if strings.HasPrefix(base, ".wh.") {
+			deleted := path.Join(dir, strings.TrimPrefix(base, ".wh."))
+			for k := range found {
+				if k == deleted || strings.HasPrefix(k, deleted+"/") {
+					delete(found, k)
+				}
+			}
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch {
+		case p == "/lib/apk/db/installed":
+			pkgs, err := parseAPK(tr, p)
+			if err != nil {
+				return err
+			}
+			found[p] = pkgs
+		case p == "/var/lib/dpkg/status" || strings.HasPrefix(p, "/var/lib/dpkg/status.d/"):
+			pkgs, err := parseDPKG(tr, p)
+			if err != nil {
+				return err
+			}
+			found[p] = pkgs
+		case h.FileInfo().Mode()&0111 != 0 && h.Size < maxBinarySize:
+			pkgs, err := parseGoBinary(tr, p)
+			if err != nil {
+				return err
+			}
+			if len(pkgs) > 0 {
+				found[p] = pkgs
+			}
+		}
+	}
+}
+
+// parseStanzas splits "Key: value" records separated by blank lines.
+func parseStanzas(rd io.Reader, sep string) ([]map[string]string, error) {
+	var out []map[string]string
+	current := make(map[string]string)
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				out = append(out, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+		current[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		out = append(out, current)
+	}
+	return out, nil
+}
+
+func parseAPK(rd io.Reader, location string) ([]*Package, error) {
+	stanzas, err := parseStanzas(rd, ":")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Package
+	for _, s := range stanzas {
+		if s["P"] == "" {
+			continue
+		}
+		out = append(out, &Package{
+			Type:     "apk",
+			Name:     s["P"],
+			Version:  s["V"],
+			License:  s["L"],
+			Location: location,
+		})
+	}
+	return out, nil
+}
+
+func parseDPKG(rd io.Reader, location string) ([]*Package, error) {
+	stanzas, err := parseStanzas(rd, ": ")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Package
+	for _, s := range stanzas {
+		if s["Package"] == "" {
+			continue
+		}
+		if status, ok := s["Status"]; ok && !strings.HasSuffix(status, "installed") {
+			continue
+		}
+		out = append(out, &Package{
+			Type:     "deb",
+			Name:     s["Package"],
+			Version:  s["Version"],
+			Location: location,
+		})
+	}
+	return out, nil
+}
+
+func parseGoBinary(rd io.Reader, location string) ([]*Package, error) {
+	raw, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := buildinfo.Read(bytes.NewReader(raw))
+	if err != nil {
+		// not a go binary
+		return nil, nil
+	}
+
+	var out []*Package
+	if info.Main.Path != "" {
+		out = append(out, &Package{
+			Type:     "golang",
+			Name:     info.Main.Path,
+			Version:  info.Main.Version,
+			Location: location,
+		})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		out = append(out, &Package{
+			Type:     "golang",
+			Name:     dep.Path,
+			Version:  dep.Version,
+			Location: location,
+		})
+	}
+	out = append(out, &Package{
+		Type:     "golang",
+		Name:     "stdlib",
+		Version:  info.GoVersion,
+		Location: location,
+	})
+	return out, nil
+}
+
+func encodeSPDX(doc *Document) ([]byte, error) {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		Version          string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		LicenseConcluded string        `json:"licenseConcluded"`
+		LicenseDeclared  string        `json:"licenseDeclared"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	pkgs := []pkg{{
+		SPDXID:           "SPDXRef-Image",
+		Name:             doc.Image,
+		Version:          doc.Digest,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		ExternalRefs: []externalRef{{
+			Category: "PACKAGE-MANAGER",
+			Type:     "purl",
+			Locator:  fmt.Sprintf("pkg:oci/%s@%s", path.Base(doc.Image), doc.Digest),
+		}},
+	}}
+	relationships := []relationship{{
+		Element: "SPDXRef-DOCUMENT",
+		Type:    "DESCRIBES",
+		Related: "SPDXRef-Image",
+	}}
+
+	for i, p := range doc.Packages {
+		license := "NOASSERTION"
+		if p.License != "" {
+			license = p.License
+		}
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		pkgs = append(pkgs, pkg{
+			SPDXID:           id,
+			Name:             p.Name,
+			Version:          p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  license,
+			ExternalRefs: []externalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  p.PURL(),
+			}},
+		})
+		relationships = append(relationships, relationship{
+			Element: "SPDXRef-Image",
+			Type:    "CONTAINS",
+			Related: id,
+		})
+	}
+
+	out := map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              doc.Image + "@" + doc.Digest,
+		"documentNamespace": fmt.Sprintf("https://contextcloud.io/ccb/spdx/%s-%s", path.Base(doc.Image), uuid.NewString()),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: " + doc.Tool},
+		},
+		"packages":      pkgs,
+		"relationships": relationships,
+	}
+	return json.Marshal(out)
+}
+
+func encodeCycloneDX(doc *Document) ([]byte, error) {
+	type license struct {
+		License map[string]string `json:"license"`
+	}
+	type component struct {
+		BOMRef     string              `json:"bom-ref,omitempty"`
+		Type       string              `json:"type"`
+		Name       string              `json:"name"`
+		Version    string              `json:"version,omitempty"`
+		PURL       string              `json:"purl,omitempty"`
+		Licenses   []license           `json:"licenses,omitempty"`
+		Properties []map[string]string `json:"properties,omitempty"`
+	}
+
+	var components []component
+	for _, p := range doc.Packages {
+		c := component{
+			BOMRef:  p.PURL() + "?location=" + p.Location,
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL(),
+			Properties: []map[string]string{{
+				"name":  "ccb:location",
+				"value": p.Location,
+			}},
+		}
+		if p.License != "" {
+			c.Licenses = []license{{License: map[string]string{"name": p.License}}}
+		}
+		components = append(components, c)
+	}
+
+	out := map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuid.NewString(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []component{{Type: "application", Name: doc.Tool}},
+			},
+			"component": component{
+				Type:    "container",
+				Name:    doc.Image,
+				Version: doc.Digest,
+			},
+		},
+		"components": components,
+	}
+	return json.Marshal(out)
+}
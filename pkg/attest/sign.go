@@ -0,0 +1,168 @@
+package attest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	// ErrInvalidKey when the signing key can't be parsed
+	ErrInvalidKey = errors.New("invalid signing key")
+	// ErrDecrypt when an encrypted key can't be opened with the password
+	ErrDecrypt = errors.New("unable to decrypt signing key")
+)
+
+// PasswordEnv holds the password for encrypted cosign keys.
+const PasswordEnv = "COSIGN_PASSWORD"
+
+// Signer signs payloads the way cosign does for a given key.
+type Signer struct {
+	key crypto.Signer
+}
+
+// Public returns the public key of the signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign returns the base64 encoded signature of payload.
+func (s *Signer) Sign(payload []byte) (string, error) {
+	var (
+		sig []byte
+		err error
+	)
+
+	switch s.key.(type) {
+	case ed25519.PrivateKey:
+		sig, err = s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		sum := sha256.Sum256(payload)
+		sig, err = s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// LoadSigner reads a PEM encoded private key. Encrypted cosign keys are
+// opened with the password in COSIGN_PASSWORD.
+func LoadSigner(filename string) (*Signer, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		der, derr := decryptKey(block.Bytes, []byte(os.Getenv(PasswordEnv)))
+		if derr != nil {
+			return nil, derr
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("%w: unsupported pem type %s", ErrInvalidKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &Signer{k}, nil
+	case *rsa.PrivateKey:
+		return &Signer{k}, nil
+	case ed25519.PrivateKey:
+		return &Signer{k}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, key)
+	}
+}
+
+// encryptedKey is the sigstore encrypted key format written by cosign generate-key-pair.
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func decryptKey(raw []byte, password []byte) ([]byte, error) {
+	var enc encryptedKey
+	if err := json.Unmarshal(raw, &enc); err != nil {
+		return nil, err
+	}
+	if enc.KDF.Name != "scrypt" || enc.Cipher.Name != "nacl/secretbox" || len(enc.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("%w: unsupported encryption %s/%s", ErrInvalidKey, enc.KDF.Name, enc.Cipher.Name)
+	}
+
+	secret, err := scrypt.Key(password, enc.KDF.Salt, enc.KDF.Params.N, enc.KDF.Params.R, enc.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], secret)
+	copy(nonce[:], enc.Cipher.Nonce)
+
+	out, ok := secretbox.Open(nil, enc.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// SimpleSigningPayload is the cosign container image signature payload for a digest.
+func SimpleSigningPayload(repository string, digest string) ([]byte, error) {
+	payload := map[string]interface{}{
+		"critical": map[string]interface{}{
+			"identity": map[string]string{
+				"docker-reference": repository,
+			},
+			"image": map[string]string{
+				"docker-manifest-digest": digest,
+			},
+			"type": "cosign container image signature",
+		},
+		"optional": nil,
+	}
+	return json.Marshal(payload)
+}
@@ -8,8 +8,6 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-
-	"github.com/denormal/go-gitignore"
 )
 
 type ArchiveInfo struct {
@@ -37,33 +35,24 @@ func (info *ArchiveInfo) addFile(tw *tar.Writer) error {
 }
 
 func (info *ArchiveInfo) addDir(tw *tar.Writer) error {
-	// Make a TAR header for the file
-	var ignores []gitignore.GitIgnore
-	for _, filename := range ignoreNames {
-		ignorePath := path.Join(info.Folder, filename)
-		ignore, err := gitignore.NewFromFile(ignorePath)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		}
-		if ignore != nil {
-			ignores = append(ignores, ignore)
-		}
+	ignore, err := LoadIgnore(info.Path)
+	if err != nil {
+		return err
 	}
 
 	walkFn := func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if fi.Mode().IsDir() {
-			return nil
-		}
-
 		// skip stuff
-		for _, ign := range ignores {
-			m := ign.Match(p)
-			if m != nil && m.Ignore() {
-				return nil
+		if ignore.Ignored(p, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+		if fi.Mode().IsDir() {
+			return nil
 		}
 
 		var link string
@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"strings"
+
+	"github.com/distribution/reference"
+	cliconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// Auth resolves registry credentials for the images a build touches.
+type Auth interface {
+	// Lookup returns the credentials for the registry hosting image.
+	Lookup(image string) (registry.AuthConfig, error)
+	// Encode returns the credentials for the registry hosting image encoded for the X-Registry-Auth header.
+	Encode(image string) (string, error)
+	// Configs returns the credentials for every registry hosting one of images.
+	Configs(images ...string) (map[string]registry.AuthConfig, error)
+}
+
+// NewDockerAuth resolves credentials from the docker CLI config and its credential helpers.
+// The username and password override whatever is configured for registryHost.
+func NewDockerAuth(registryHost string, username string, password string) (Auth, error) {
+	cfg, err := cliconfig.Load("")
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerAuth{
+		cfg:      cfg,
+		host:     normalizeHost(registryHost),
+		username: username,
+		password: password,
+	}, nil
+}
+
+type dockerAuth struct {
+	cfg      *configfile.ConfigFile
+	host     string
+	username string
+	password string
+}
+
+func (a *dockerAuth) lookupHost(host string) (registry.AuthConfig, error) {
+	ac, err := a.cfg.GetAuthConfig(host)
+	if err != nil {
+		return registry.AuthConfig{}, err
+	}
+
+	out := registry.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		ServerAddress: ac.ServerAddress,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	}
+	if host == a.host {
+		if a.username != "" {
+			out.Username = a.username
+		}
+		if a.password != "" {
+			out.Password = a.password
+		}
+	}
+	if out.ServerAddress == "" {
+		out.ServerAddress = host
+	}
+	return out, nil
+}
+
+func (a *dockerAuth) Lookup(image string) (registry.AuthConfig, error) {
+	return a.lookupHost(imageHost(image))
+}
+
+func (a *dockerAuth) Encode(image string) (string, error) {
+	ac, err := a.Lookup(image)
+	if err != nil {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(ac)
+}
+
+func (a *dockerAuth) Configs(images ...string) (map[string]registry.AuthConfig, error) {
+	out := make(map[string]registry.AuthConfig)
+	for _, image := range images {
+		host := imageHost(image)
+		if _, ok := out[host]; ok {
+			continue
+		}
+
+		ac, err := a.lookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		out[host] = ac
+	}
+	return out, nil
+}
+
+// imageHost returns the registry hostname as keyed in the docker config.
+func imageHost(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return normalizeHost(image)
+	}
+	return normalizeHost(reference.Domain(named))
+}
+
+func normalizeHost(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	if i := strings.Index(host, "/"); i > -1 {
+		host = host[:i]
+	}
+
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubServer
+	default:
+		return host
+	}
+}
+
+// Keychain adapts auth for registry clients that talk to the registry directly.
+func Keychain(auth Auth) authn.Keychain {
+	return &keychain{auth}
+}
+
+type keychain struct {
+	auth Auth
+}
+
+func (k *keychain) Resolve(res authn.Resource) (authn.Authenticator, error) {
+	repo, ok := res.(name.Repository)
+	if !ok || k.auth == nil {
+		return authn.Anonymous, nil
+	}
+
+	ac, err := k.auth.Lookup(repo.Name())
+	if err != nil {
+		return nil, err
+	}
+	if ac.Username == "" && ac.Password == "" && ac.Auth == "" && ac.IdentityToken == "" && ac.RegistryToken == "" {
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      ac.Username,
+		Password:      ac.Password,
+		Auth:          ac.Auth,
+		IdentityToken: ac.IdentityToken,
+		RegistryToken: ac.RegistryToken,
+	}), nil
+}
@@ -4,18 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/contextcloud/ccb/pkg/attest"
+	"github.com/contextcloud/ccb/pkg/policy"
 	"github.com/contextcloud/ccb/pkg/print"
 	"github.com/contextcloud/ccb/pkg/utils"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/neilotoole/errgroup"
 )
 
 type BuildResult struct {
-	Image string
+	Image     string
+	BaseImage string
+	// Scratch when the image isn't built on another
+	Scratch bool
 }
 
 type Build interface {
@@ -36,22 +44,44 @@ type BuildFunction struct {
 	TemplatePath string
 }
 
+// Service to build
+type Service struct {
+	Name        string
+	Template    string
+	TemplateRef string
+	Version     string
+	Args        BuildArgs
+	Labels      map[string]string
+}
+
 type Options struct {
 	Log        print.Log
 	WorkingDir string
 	PoolSize   int
 	Network    string
 
-	Push         bool
-	Registry     string
-	Prefix       string
-	Tag          string
-	RegistryAuth string
+	Push     bool
+	Registry string
+	Prefix   string
+	Tag      string
+	Auth     Auth
+
+	// Source and Revision are stamped on images as OCI labels
+	Source   string
+	Revision string
+
+	// Policy is checked before images are pushed
+	Policy *policy.Policy
+
+	// SignKey and SBOM attest images once they're pushed
+	SignKey string
+	SBOM    string
+	Version string
 }
 
 // Builder for building stuff.
 type Builder interface {
-	AddService(name string, template string, args BuildArgs) error
+	AddService(svc *Service) error
 	Build(ctx context.Context) ([]string, error)
 }
 
@@ -64,15 +94,39 @@ func NewBuilder(opts *Options) (Builder, error) {
 	c := &builder{
 		Options: opts,
 		cli:     cli,
+		created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if opts.SignKey != "" || opts.SBOM != "" {
+		attester, err := attest.NewAttester(&attest.Options{
+			Log:      opts.Log,
+			Keychain: Keychain(opts.Auth),
+			Version:  opts.Version,
+			SignKey:  opts.SignKey,
+			SBOM:     opts.SBOM,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.attester = attester
 	}
 	return c, nil
 }
 
+// dockerClient is the part of the docker api the builds use
+type dockerClient interface {
+	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ImagePush(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error)
+}
+
 // Client for building stuff in parallel
 type builder struct {
 	*Options
 
-	cli       *client.Client
+	cli       dockerClient
+	created   string
+	attester  attest.Attester
 	functions []Build
 }
 
@@ -88,16 +142,24 @@ func (b *builder) imageName(name string) string {
 	return fmt.Sprintf("%s/%s:%s", b.Registry, n, b.Tag)
 }
 
-func (b *builder) toBuild(name string, template string, args BuildArgs) (Build, error) {
-	if utils.IsDockerTemplate(template) {
-		return NewDockerfileBuild(b, name, args)
+// authConfigs resolves credentials for every registry the dockerfile pulls from.
+func (b *builder) authConfigs(dockerfile []byte, args BuildArgs) (map[string]registry.AuthConfig, error) {
+	if b.Auth == nil {
+		return nil, nil
 	}
+	return b.Auth.Configs(baseImages(dockerfile, args)...)
+}
 
-	return NewPackBuild(b, name, template, args)
+func (b *builder) toBuild(svc *Service) (Build, error) {
+	if utils.IsDockerTemplate(svc.Template) {
+		return NewDockerfileBuild(b, svc)
+	}
+
+	return NewPackBuild(b, svc)
 }
 
-func (b *builder) AddService(name string, template string, args BuildArgs) error {
-	build, err := b.toBuild(name, template, args)
+func (b *builder) AddService(svc *Service) error {
+	build, err := b.toBuild(svc)
 	if err != nil {
 		return err
 	}
@@ -106,14 +168,54 @@ func (b *builder) AddService(name string, template string, args BuildArgs) error
 }
 
 func (b *builder) Build(ctx context.Context) ([]string, error) {
+	var out []string
+	for _, v := range b.functions {
+		out = append(out, v.Name())
+	}
+
+	results, err := b.run(ctx)
+	if err != nil {
+		return out, err
+	}
+
+	// check all of them so every violation is reported before anything is pushed
+	var failed []error
+	for i, v := range b.functions {
+		if err := b.check(ctx, v.Name(), results[i]); err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return out, errors.Join(failed...)
+	}
+
 	cpus := runtime.NumCPU()
 	g, ctx := errgroup.WithContextN(ctx, cpus, b.PoolSize)
+	for _, result := range results {
+		image := result.Image
 
-	var out []string
-	for _, doc := range b.functions {
-		v := doc
-		name := v.Name()
-		out = append(out, name)
+		g.Go(func() error {
+			// do we push?
+			digest, err := b.push(ctx, image)
+			if err != nil {
+				return err
+			}
+
+			return b.attest(ctx, image, digest)
+		})
+	}
+
+	return out, g.Wait()
+}
+
+// run the builds in parallel, the results are in the order of the functions
+func (b *builder) run(ctx context.Context) ([]*BuildResult, error) {
+	cpus := runtime.NumCPU()
+	g, ctx := errgroup.WithContextN(ctx, cpus, b.PoolSize)
+
+	results := make([]*BuildResult, len(b.functions))
+	for i, doc := range b.functions {
+		i, v := i, doc
 
 		g.Go(func() error {
 			// run the build
@@ -124,41 +226,90 @@ func (b *builder) Build(ctx context.Context) ([]string, error) {
 			if result == nil || result.Image == "" {
 				return errors.New("no image")
 			}
-
-			// do we push?
-			if err := b.push(ctx, result.Image); err != nil {
-				return err
-			}
-
+			results[i] = result
 			return nil
 		})
+	}
 
+	return results, g.Wait()
+}
+
+func (b *builder) check(ctx context.Context, name string, result *BuildResult) error {
+	if b.Policy == nil {
+		return nil
 	}
 
-	return out, g.Wait()
+	inspect, _, err := b.cli.ImageInspectWithRaw(ctx, result.Image)
+	if err != nil {
+		return err
+	}
+
+	img := &policy.Image{
+		Name:      result.Image,
+		Size:      inspect.Size,
+		BaseImage: result.BaseImage,
+		Scratch:   result.Scratch,
+	}
+	if inspect.Config != nil {
+		img.User = inspect.Config.User
+		img.Labels = inspect.Config.Labels
+	}
+
+	violations := b.Policy.Evaluate(img)
+	if len(violations) > 0 {
+		return &policy.Error{
+			Function:   name,
+			Violations: violations,
+		}
+	}
+
+	b.Log.Printf("%s: Passed policy\n", name)
+	return nil
 }
 
-func (b *builder) push(ctx context.Context, image string) error {
+func (b *builder) push(ctx context.Context, image string) (string, error) {
 	if !b.Push {
-		return nil
+		return "", nil
 	}
 
 	b.Log.Printf("%s: Pushing image\n", image)
 
+	var registryAuth string
+	if b.Auth != nil {
+		encoded, err := b.Auth.Encode(image)
+		if err != nil {
+			return "", err
+		}
+		registryAuth = encoded
+	}
+
 	pushOptions := types.ImagePushOptions{
-		RegistryAuth: b.RegistryAuth,
+		RegistryAuth: registryAuth,
 	}
 	pushResp, err := b.cli.ImagePush(ctx, image, pushOptions)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer pushResp.Close()
 
 	// parse the output
-	_, err = pushResult(pushResp, b.Log)
+	auxs, err := pushResult(pushResp, b.Log)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	var digest string
+	for _, aux := range auxs {
+		if aux.Digest != "" {
+			digest = aux.Digest
+		}
+	}
+	return digest, nil
+}
+
+func (b *builder) attest(ctx context.Context, image string, digest string) error {
+	if b.attester == nil || !b.Push {
+		return nil
+	}
+	return b.attester.Attest(ctx, image, digest)
 }
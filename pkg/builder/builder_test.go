@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/policy"
+	"github.com/contextcloud/ccb/pkg/print"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+type fakeBuild struct {
+	name  string
+	image string
+}
+
+func (f *fakeBuild) Name() string {
+	return f.name
+}
+
+func (f *fakeBuild) Run(ctx context.Context) (*BuildResult, error) {
+	return &BuildResult{Image: f.image, BaseImage: "gcr.io/distroless/static"}, nil
+}
+
+// fakeDocker runs images as the user they're named after
+type fakeDocker struct {
+	mu     sync.Mutex
+	pushed []string
+}
+
+func (f *fakeDocker) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	return types.ImageBuildResponse{}, errors.New("not implemented")
+}
+
+func (f *fakeDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	user := strings.SplitN(image, ":", 2)[0]
+	return types.ImageInspect{Config: &container.Config{User: user}}, nil, nil
+}
+
+func (f *fakeDocker) ImagePush(ctx context.Context, image string, options types.ImagePushOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushed = append(f.pushed, image)
+	return io.NopCloser(strings.NewReader("{}\n")), nil
+}
+
+func Test_BuildPolicy(t *testing.T) {
+	cli := &fakeDocker{}
+	b := &builder{
+		Options: &Options{Log: print.NewLog(io.Discard), PoolSize: 2, Push: true, Policy: &policy.Policy{NonRoot: true}},
+		cli:     cli,
+		functions: []Build{
+			&fakeBuild{name: "api", image: "root:latest"},
+			&fakeBuild{name: "profile", image: "nonroot:latest"},
+			&fakeBuild{name: "worker", image: "0:latest"},
+		},
+	}
+
+	_, err := b.Build(context.Background())
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Errorf("Expected the violations of every function, got %v", err)
+		return
+	}
+	var failed []string
+	for _, err := range joined.Unwrap() {
+		var perr *policy.Error
+		if errors.As(err, &perr) {
+			failed = append(failed, perr.Function)
+		}
+	}
+	if strings.Join(failed, " ") != "api worker" {
+		t.Errorf("Unexpected functions failing policy: %v", failed)
+	}
+	if len(cli.pushed) != 0 {
+		t.Errorf("Expected nothing pushed, got %v", cli.pushed)
+		return
+	}
+
+	b.functions = b.functions[1:2]
+	if _, err := b.Build(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(cli.pushed) != 1 || cli.pushed[0] != "nonroot:latest" {
+		t.Errorf("Unexpected pushes: %v", cli.pushed)
+	}
+}
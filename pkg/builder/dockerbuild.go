@@ -11,6 +11,7 @@ import (
 
 type dockerfileBuild struct {
 	builder    *builder
+	service    *Service
 	name       string
 	buildArgs  BuildArgs
 	filesPath  string
@@ -31,6 +32,17 @@ func (d *dockerfileBuild) Run(ctx context.Context) (*BuildResult, error) {
 		return nil, err
 	}
 
+	dockerfile, err := os.ReadFile(d.dockerPath)
+	if err != nil {
+		return nil, err
+	}
+	authConfigs, err := d.builder.authConfigs(dockerfile, d.buildArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	final := finalStage(dockerfile, d.buildArgs)
+
 	imageName := d.builder.imageName(d.name)
 	buildOptions := types.ImageBuildOptions{
 		Context:     reader,
@@ -39,6 +51,8 @@ func (d *dockerfileBuild) Run(ctx context.Context) (*BuildResult, error) {
 		Tags:        []string{imageName},
 		BuildArgs:   d.buildArgs,
 		NetworkMode: d.builder.Network,
+		AuthConfigs: authConfigs,
+		Labels:      d.builder.imageLabels(d.service, final.Image),
 	}
 
 	imageResp, err := d.builder.cli.ImageBuild(ctx, reader, buildOptions)
@@ -58,13 +72,15 @@ func (d *dockerfileBuild) Run(ctx context.Context) (*BuildResult, error) {
 	}
 
 	return &BuildResult{
-		Image: imageName,
+		Image:     imageName,
+		BaseImage: final.Image,
+		Scratch:   final.Scratch,
 	}, nil
 }
 
-func NewDockerfileBuild(builder *builder, name string, args BuildArgs) (Build, error) {
-	fpath := path.Join(builder.WorkingDir, name)
-	dpath := path.Join(builder.WorkingDir, name, "Dockerfile")
+func NewDockerfileBuild(builder *builder, svc *Service) (Build, error) {
+	fpath := path.Join(builder.WorkingDir, svc.Name)
+	dpath := path.Join(builder.WorkingDir, svc.Name, "Dockerfile")
 
 	// check if the files exists
 	if _, err := os.Stat(fpath); err != nil {
@@ -77,8 +93,9 @@ func NewDockerfileBuild(builder *builder, name string, args BuildArgs) (Build, e
 
 	return &dockerfileBuild{
 		builder:    builder,
-		name:       name,
-		buildArgs:  args,
+		service:    svc,
+		name:       svc.Name,
+		buildArgs:  svc.Args,
 		filesPath:  fpath,
 		dockerPath: dpath,
 	}, nil
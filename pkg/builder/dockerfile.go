@@ -0,0 +1,147 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// dockerfileStage is a FROM instruction with the external image it resolves
+// to, Scratch when it builds on nothing.
+type dockerfileStage struct {
+	Name    string
+	Image   string
+	Scratch bool
+}
+
+// parseStages returns the stages of a Dockerfile in order. Each stage's Image
+// is the external image it builds on, following references to earlier
+// stages; it's empty for scratch and images that can't be resolved from the
+// build args.
+func parseStages(dockerfile []byte, args BuildArgs) []dockerfileStage {
+	vars := make(map[string]string)
+	stages := make(map[string]dockerfileStage)
+	seenFrom := false
+
+	var out []dockerfileStage
+	for _, line := range dockerfileInstructions(dockerfile) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			// only global args before the first FROM apply to FROM lines
+			if seenFrom {
+				continue
+			}
+			kv := strings.SplitN(fields[1], "=", 2)
+			if v, ok := args[kv[0]]; ok && v != nil {
+				vars[kv[0]] = *v
+			} else if len(kv) == 2 {
+				vars[kv[0]] = strings.Trim(kv[1], `"'`)
+			}
+		case "FROM":
+			seenFrom = true
+			fields = fields[1:]
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			image := os.Expand(fields[0], func(key string) string {
+				if v, ok := args[key]; ok && v != nil {
+					return *v
+				}
+				return vars[key]
+			})
+
+			stage := dockerfileStage{}
+			if len(fields) >= 3 && strings.EqualFold(fields[1], "as") {
+				stage.Name = strings.ToLower(fields[2])
+			}
+			if base, ok := stages[strings.ToLower(image)]; ok {
+				stage.Image = base.Image
+				stage.Scratch = base.Scratch
+			} else if isExternalImage(image) {
+				stage.Image = image
+			} else {
+				stage.Scratch = strings.EqualFold(image, "scratch")
+			}
+
+			if stage.Name != "" {
+				stages[stage.Name] = stage
+			}
+			out = append(out, stage)
+		}
+	}
+	return out
+}
+
+// isExternalImage is true for images pulled from a registry.
+func isExternalImage(image string) bool {
+	// image ids (like FUNCTION_IMG) are already local to the daemon
+	if image == "" || strings.EqualFold(image, "scratch") || strings.HasPrefix(image, "sha256:") {
+		return false
+	}
+	_, err := reference.ParseNormalizedNamed(image)
+	return err == nil
+}
+
+// baseImages returns the external images a Dockerfile builds FROM, in order.
+func baseImages(dockerfile []byte, args BuildArgs) []string {
+	seen := make(map[string]bool)
+
+	var out []string
+	for _, stage := range parseStages(dockerfile, args) {
+		if stage.Image == "" || seen[stage.Image] {
+			continue
+		}
+		seen[stage.Image] = true
+		out = append(out, stage.Image)
+	}
+	return out
+}
+
+// finalStage returns the last stage of a Dockerfile, its Image is the
+// external image the function is built on.
+func finalStage(dockerfile []byte, args BuildArgs) dockerfileStage {
+	stages := parseStages(dockerfile, args)
+	if len(stages) == 0 {
+		return dockerfileStage{}
+	}
+	return stages[len(stages)-1]
+}
+
+// dockerfileInstructions joins continuation lines and drops comments.
+func dockerfileInstructions(dockerfile []byte) []string {
+	var out []string
+	var current string
+
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		current += line
+		if current != "" {
+			out = append(out, current)
+		}
+		current = ""
+	}
+	if current != "" {
+		out = append(out, current)
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_BaseImages(t *testing.T) {
+	dockerfile := []byte(`ARG GO_VERSION=1.21
+ARG FUNCTION_IMG
+FROM ${FUNCTION_IMG} AS function
+
+# build the handler
+FROM --platform=linux/amd64 golang:${GO_VERSION} AS build
+COPY --from=function /app /app
+
+FROM gcr.io/distroless/static \
+  AS final
+COPY --from=build /app /app
+FROM build
+FROM scratch
+`)
+
+	img := "sha256:1a2b3c"
+	images := baseImages(dockerfile, BuildArgs{"FUNCTION_IMG": &img})
+
+	expected := []string{"golang:1.21", "gcr.io/distroless/static"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Invalid images: %v", images)
+		return
+	}
+
+	if final := finalStage(dockerfile, nil); final.Image != "" || !final.Scratch {
+		t.Errorf("Invalid final stage: %+v", final)
+		return
+	}
+
+	trimmed := bytes.TrimSuffix(dockerfile, []byte("FROM scratch\n"))
+	if final := finalStage(trimmed, nil); final.Image != "golang:1.21" || final.Scratch {
+		t.Errorf("Invalid final stage: %+v", final)
+		return
+	}
+
+	// the base image comes from an arg that isn't set
+	unresolved := []byte("ARG BASE\nFROM ${BASE}\nCOPY app /app\n")
+	if final := finalStage(unresolved, nil); final.Image != "" || final.Scratch {
+		t.Errorf("Invalid final stage: %+v", final)
+		return
+	}
+}
+
+func Test_ImageHost(t *testing.T) {
+	hosts := map[string]string{
+		"golang:1.21":                  dockerHubServer,
+		"docker.io/library/alpine":     dockerHubServer,
+		"gcr.io/distroless/static":     "gcr.io",
+		"localhost:5000/ccb/profile:1": "localhost:5000",
+	}
+
+	for image, expected := range hosts {
+		if host := imageHost(image); host != expected {
+			t.Errorf("Invalid host for %s: %s", image, host)
+		}
+	}
+
+	if host := normalizeHost("https://ghcr.io/contextcloud/"); host != "ghcr.io" {
+		t.Errorf("Invalid registry host: %s", host)
+	}
+}
@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/denormal/go-gitignore"
+)
+
+// Ignore is the ignore files of a directory, the files they match are left
+// out of the build context
+type Ignore struct {
+	dir     string
+	ignores []gitignore.GitIgnore
+}
+
+// LoadIgnore reads the ignore files in the root of a directory
+func LoadIgnore(dir string) (*Ignore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Ignore{dir: abs}
+	for _, filename := range ignoreNames {
+		ignore, err := gitignore.NewFromFile(filepath.Join(abs, filename))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if ignore != nil {
+			out.ignores = append(out.ignores, ignore)
+		}
+	}
+	return out, nil
+}
+
+// Ignored checks a path inside the directory, it doesn't need to exist so
+// removed files can be matched too
+func (i *Ignore) Ignored(p string, isDir bool) bool {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(i.dir, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	// a file is ignored when any of its parents are
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for n := 1; n <= len(parts); n++ {
+		if i.match(strings.Join(parts[:n], "/"), n < len(parts) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Ignore) match(rel string, isDir bool) bool {
+	for _, ign := range i.ignores {
+		if m := ign.Relative(rel, isDir); m != nil && m.Ignore() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIgnoreFile checks if a file name is one of the ignore files
+func IsIgnoreFile(name string) bool {
+	for _, n := range ignoreNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
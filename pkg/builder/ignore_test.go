@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func Test_Ignore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":                "node_modules/\n*.log\n",
+		".dockerignore":             "secrets.env\n",
+		"main.go":                   "package main",
+		"debug.log":                 "",
+		"secrets.env":               "",
+		"node_modules/left-pad.js":  "",
+		"pkg/handler.go":            "package pkg",
+		"pkg/nested/node_modules/x": "",
+	}
+	for name, body := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	ignore, err := LoadIgnore(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := map[string]bool{
+		"main.go":          false,
+		"debug.log":        true,
+		"secrets.env":      true,
+		"pkg/gone.log":     true,
+		"pkg/handler.go":   false,
+		"../outside.log":   false,
+		"node_modules/new": true,
+	}
+	for name, expected := range cases {
+		if ignored := ignore.Ignored(filepath.Join(dir, name), false); ignored != expected {
+			t.Errorf("%s ignored %v, expected %v", name, ignored, expected)
+		}
+	}
+	if !ignore.Ignored(filepath.Join(dir, "node_modules"), true) {
+		t.Error("node_modules should be ignored")
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := NewDirArchive(dir, true).Write(tw); err != nil {
+		t.Error(err)
+		return
+	}
+	tw.Close()
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		names = append(names, h.Name)
+	}
+	sort.Strings(names)
+
+	base := filepath.Base(dir)
+	expected := []string{base + "/.dockerignore", base + "/.gitignore", base + "/main.go", base + "/pkg/handler.go"}
+	if len(names) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+		return
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, names)
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"github.com/contextcloud/ccb/pkg/utils"
+)
+
+// Standard OCI annotations stamped on built images.
+const (
+	LabelCreated  = "org.opencontainers.image.created"
+	LabelSource   = "org.opencontainers.image.source"
+	LabelRevision = "org.opencontainers.image.revision"
+	LabelVersion  = "org.opencontainers.image.version"
+	LabelTitle    = "org.opencontainers.image.title"
+	LabelBaseName = "org.opencontainers.image.base.name"
+)
+
+// ccb labels to trace an image back to the stack.
+const (
+	LabelFunction    = "dev.contextcloud.ccb.function"
+	LabelTemplate    = "dev.contextcloud.ccb.template"
+	LabelTemplateRef = "dev.contextcloud.ccb.template.ref"
+)
+
+// imageLabels for a service, custom labels from the stack win.
+func (b *builder) imageLabels(svc *Service, baseImage string) map[string]string {
+	labels := map[string]string{
+		LabelCreated:     b.created,
+		LabelSource:      b.Source,
+		LabelRevision:    b.Revision,
+		LabelVersion:     svc.Version,
+		LabelTitle:       svc.Name,
+		LabelBaseName:    baseImage,
+		LabelFunction:    svc.Name,
+		LabelTemplate:    svc.Template,
+		LabelTemplateRef: svc.TemplateRef,
+	}
+
+	out := make(map[string]string)
+	for k, v := range utils.MergeMap(labels, svc.Labels) {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
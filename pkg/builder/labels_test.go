@@ -0,0 +1,46 @@
+package builder
+
+import "testing"
+
+func Test_ImageLabels(t *testing.T) {
+	b := &builder{
+		Options: &Options{
+			Source:   "https://github.com/contextcloud/examples",
+			Revision: "abc123",
+		},
+		created: "2024-01-01T00:00:00Z",
+	}
+
+	svc := &Service{
+		Name:     "profile",
+		Template: "golang",
+		Version:  "0.1",
+		Labels: map[string]string{
+			"team":        "platform",
+			LabelSource:   "https://github.com/contextcloud/profile",
+			LabelRevision: "",
+		},
+	}
+
+	labels := b.imageLabels(svc, "gcr.io/distroless/static")
+
+	expected := map[string]string{
+		LabelCreated:  "2024-01-01T00:00:00Z",
+		LabelSource:   "https://github.com/contextcloud/profile",
+		LabelVersion:  "0.1",
+		LabelTitle:    "profile",
+		LabelBaseName: "gcr.io/distroless/static",
+		LabelFunction: "profile",
+		LabelTemplate: "golang",
+		"team":        "platform",
+	}
+	if len(labels) != len(expected) {
+		t.Errorf("Invalid labels: %v", labels)
+		return
+	}
+	for k, v := range expected {
+		if labels[k] != v {
+			t.Errorf("Invalid label %s: %s", k, labels[k])
+		}
+	}
+}
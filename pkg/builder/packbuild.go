@@ -8,12 +8,14 @@ import (
 	"path"
 
 	"github.com/contextcloud/ccb/pkg/builder/resources"
+	"github.com/contextcloud/ccb/pkg/templater"
 	"github.com/contextcloud/ccb/pkg/utils"
 	"github.com/docker/docker/api/types"
 )
 
 type packBuild struct {
 	builder *builder
+	service *Service
 
 	name         string
 	buildArgs    BuildArgs
@@ -65,14 +67,14 @@ func (d *packBuild) function(ctx context.Context) (string, error) {
 	return auxs[len(auxs)-1].Id, nil
 }
 
-func (d *packBuild) handler(ctx context.Context, filesImage string) (string, error) {
+func (d *packBuild) handler(ctx context.Context, filesImage string) (*BuildResult, error) {
 	// build the first one!
 	d.builder.Log.Printf("%s: Building function\n", d.name)
 
 	template := NewDirArchive(d.templatePath, true)
 	reader, err := buildArchive(template)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	hargs := map[string]*string{
@@ -80,6 +82,17 @@ func (d *packBuild) handler(ctx context.Context, filesImage string) (string, err
 	}
 	args := utils.MergeMap(d.buildArgs, hargs)
 
+	dockerfile, err := os.ReadFile(path.Join(d.templatePath, "Dockerfile"))
+	if err != nil {
+		return nil, err
+	}
+	authConfigs, err := d.builder.authConfigs(dockerfile, args)
+	if err != nil {
+		return nil, err
+	}
+
+	final := finalStage(dockerfile, args)
+
 	imageName := d.builder.imageName(d.name)
 	buildOptions := types.ImageBuildOptions{
 		Context:     reader,
@@ -88,25 +101,31 @@ func (d *packBuild) handler(ctx context.Context, filesImage string) (string, err
 		Tags:        []string{imageName},
 		BuildArgs:   args,
 		NetworkMode: d.builder.Network,
+		AuthConfigs: authConfigs,
+		Labels:      d.builder.imageLabels(d.service, final.Image),
 	}
 
 	imageResp, err := d.builder.cli.ImageBuild(ctx, reader, buildOptions)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer imageResp.Body.Close()
 
 	// parse the output
 	auxs, err := buildResult(imageResp.Body, d.builder.Log)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if len(auxs) == 0 {
-		return "", errors.New("no aux data")
+		return nil, errors.New("no aux data")
 	}
 
-	return imageName, nil
+	return &BuildResult{
+		Image:     imageName,
+		BaseImage: final.Image,
+		Scratch:   final.Scratch,
+	}, nil
 }
 
 func (d *packBuild) Run(ctx context.Context) (*BuildResult, error) {
@@ -116,33 +135,39 @@ func (d *packBuild) Run(ctx context.Context) (*BuildResult, error) {
 		return nil, err
 	}
 
-	handlerImg, err := d.handler(ctx, functionImg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &BuildResult{
-		Image: handlerImg,
-	}, nil
+	return d.handler(ctx, functionImg)
 }
 
-func NewPackBuild(builder *builder, name string, template string, buildArgs BuildArgs) (Build, error) {
-	tpath := path.Join(".", ".ccb", "templates", template)
-	fpath := path.Join(builder.WorkingDir, name)
-
-	// check if the template exists
-	if _, err := os.Stat(tpath); err != nil {
+func NewPackBuild(builder *builder, svc *Service) (Build, error) {
+	tpath, err := templater.FindTemplate(builder.WorkingDir, svc.Template)
+	if err != nil {
 		return nil, err
 	}
+	fpath := path.Join(builder.WorkingDir, svc.Name)
+
 	// check if the files exists
 	if _, err := os.Stat(fpath); err != nil {
 		return nil, err
 	}
 
+	// validate against the template before docker gets involved
+	args := svc.Args
+	meta, err := templater.LoadMetadata(tpath)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		if err := meta.Validate(svc.Template, fpath, args); err != nil {
+			return nil, err
+		}
+		args = meta.Apply(args)
+	}
+
 	return &packBuild{
 		builder:      builder,
-		name:         name,
-		buildArgs:    buildArgs,
+		service:      svc,
+		name:         svc.Name,
+		buildArgs:    args,
 		filesPath:    fpath,
 		templatePath: tpath,
 	}, nil
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"embed"
 	"errors"
+	"fmt"
 	"io/fs"
 	"strings"
 	"text/template"
 
+	"github.com/contextcloud/ccb/pkg/manifests"
 	"github.com/contextcloud/ccb/pkg/parser"
 	"github.com/contextcloud/ccb/pkg/utils"
 )
@@ -25,6 +27,12 @@ var (
 	ErrNoConfig = errors.New("no config supplied")
 	// ErrInvalidFQDN when the FQDN is invalid
 	ErrInvalidFQDN = errors.New("invalid FQDN")
+	// ErrEncryptedSecret when a secret can't be read without decrypting it
+	ErrEncryptedSecret = errors.New("secret is encrypted")
+	// ErrUnsupportedRoute when the router can't express a route
+	ErrUnsupportedRoute = errors.New("route not supported by the router")
+	// ErrNoGateway when HTTPRoutes have no gateway to attach to
+	ErrNoGateway = errors.New("no gateway for the routes, set router.gateway")
 )
 
 //go:embed templates/*
@@ -47,18 +55,73 @@ var readinessProbe = &Probe{
 	PeriodSeconds:       5,
 }
 
+// defaultNodeSelector puts the functions on spot nodes unless the stack or the
+// function sets one, an empty node_selector turns it off
+var defaultNodeSelector = map[string]string{
+	"cloud.google.com/gke-spot": "true",
+}
+
+// ingressNamespaces the routers are installed in by default, their pods
+// are the ones sending requests to routed functions
+var ingressNamespaces = map[string]string{
+	manifests.RouterNginx:      "nginx-ingress",
+	manifests.RouterGatewayAPI: "envoy-gateway-system",
+	manifests.RouterIngress:    "ingress-nginx",
+}
+
 type Manager interface {
 	GenerateRoutes(routes []*parser.Route) (Manifests, error)
 	GenerateFunctions(registry string, tag string, fn []*parser.Function) (Manifests, error)
 }
 
+// Labels ccb puts on what it renders so it can find them again
+const (
+	LabelStack    = "ccb.contextcloud.dev/stack"
+	LabelFunction = "ccb.contextcloud.dev/function"
+)
+
+// Options to render manifests with
+type Options struct {
+	WorkingDir string
+	Namespace  string
+	Commit     string
+	// Stack is the name of the stack, rendered resources are labelled with it
+	Stack string
+	// Releases split the traffic of the routes of their functions
+	Releases []*parser.Release
+	// Router the routes are rendered for, nginx when it's not set
+	Router manifests.Router
+	// Network policies of the stack, Callers are the functions calling each
+	// one and have to cover the whole stack
+	Network manifests.Network
+	Callers map[string][]string
+}
+
 type manager struct {
 	workingDir string
 	namespace  string
 	commit     string
+	stack      string
+	releases   map[string]*parser.Release
+	router     manifests.Router
+	network    manifests.Network
+	callers    map[string][]string
 	funcMap    template.FuncMap
 }
 
+// stackLabels identify the stack and function a resource belongs to, they're
+// left out of selectors so changing them doesn't orphan pods
+func (m *manager) stackLabels(key string) map[string]string {
+	out := make(map[string]string)
+	if m.stack != "" {
+		out[LabelStack] = LabelValue(m.stack)
+	}
+	if key != "" {
+		out[LabelFunction] = LabelValue(key)
+	}
+	return out
+}
+
 func (m *manager) mergeEnv(all map[string]Environment, files []string, env map[string]string) (map[string]string, error) {
 	var out Environment
 	for _, name := range files {
@@ -79,6 +142,40 @@ func (m *manager) mergeEnv(all map[string]Environment, files []string, env map[s
 	return out, nil
 }
 
+// serviceEnv adds the variables every function is given
+func serviceEnv(env map[string]string, fn *parser.Function) {
+	env["SERVICENAME"] = fn.Key
+	env["VERSION"] = fn.Version
+	env["ENVIRONMENT"] = fn.Environment
+}
+
+// FunctionEnvironment is the environment a function is deployed with, the
+// envs files and env merged with the service variables.
+func FunctionEnvironment(workingDir string, fn *parser.Function) (map[string]string, error) {
+	envs := make(map[string]Environment)
+	for _, name := range fn.Envs {
+		filename, err := utils.YamlFile(workingDir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		env, err := LoadEnv(filename)
+		if err != nil {
+			return nil, err
+		}
+		envs[filename] = env
+	}
+
+	m := &manager{workingDir: workingDir}
+	env, err := m.mergeEnv(envs, fn.Envs, fn.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceEnv(env, fn)
+	return env, nil
+}
+
 func (m *manager) secretNames(all map[string]*Secret, files []string) ([]string, error) {
 	var out []string
 	for _, name := range files {
@@ -122,6 +219,10 @@ func (m *manager) executeFunction(dir string, key string, data map[string]interf
 		if err := tmpl.Execute(&tpl, data); err != nil {
 			return err
 		}
+		// templates of the other routers render nothing
+		if strings.TrimSpace(tpl.String()) == "" {
+			return nil
+		}
 		out = append(out, Manifest{
 			Type:    ToManifestType(path),
 			Key:     key,
@@ -140,12 +241,26 @@ func (m *manager) GenerateRoutes(routes []*parser.Route) (Manifests, error) {
 	var all Manifests
 
 	for _, r := range routes {
+		for _, include := range r.Routes {
+			if m.router.Kind != manifests.RouterIngress {
+				continue
+			}
+			switch {
+			case include.Redirect != "":
+				return nil, fmt.Errorf("%w: %s redirects %s", ErrUnsupportedRoute, r.Key, include.Prefix)
+			case include.Namespace != "":
+				return nil, fmt.Errorf("%w: %s includes %s from namespace %s", ErrUnsupportedRoute, r.Key, include.Prefix, include.Namespace)
+			}
+		}
+
 		data := map[string]interface{}{
-			"Key":       r.Key,
-			"Namespace": m.namespace,
-			"Commit":    m.commit,
-			"FQDN":      r.FQDN,
-			"Routes":    r.Routes,
+			"Key":         r.Key,
+			"Namespace":   m.namespace,
+			"Commit":      m.commit,
+			"FQDN":        r.FQDN,
+			"Routes":      r.Routes,
+			"Router":      m.router,
+			"StackLabels": m.stackLabels(""),
 		}
 		out, err := m.executeFunction("routes", "server", data)
 		if err != nil {
@@ -167,10 +282,7 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 	// load up the secrets and environments
 	for _, fn := range fns {
 		for _, r := range fn.Routes {
-			routes[r.Name] = append(routes[r.Name], FunctionRoute{
-				Key:   fn.Key,
-				Route: r,
-			})
+			routes[r.Name] = append(routes[r.Name], m.functionRoute(fn.Key, r))
 		}
 		for _, secret := range fn.Secrets {
 			// get the path
@@ -225,9 +337,7 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 		}
 
 		// add the service envs
-		env["SERVICENAME"] = fn.Key
-		env["VERSION"] = fn.Version
-		env["ENVIRONMENT"] = fn.Environment
+		serviceEnv(env, fn)
 
 		secrets, err := m.secretNames(secrets, fn.Secrets)
 		if err != nil {
@@ -268,6 +378,17 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 			resources.Requests.Memory = fn.Requests.Memory
 		}
 
+		scheduling := manifests.Scheduling{}
+		if fn.Scheduling != nil {
+			scheduling = *fn.Scheduling
+		}
+		if scheduling.NodeSelector == nil {
+			scheduling.NodeSelector = defaultNodeSelector
+		}
+		if scheduling.Spread == "" {
+			scheduling.Spread = manifests.SpreadDoNotSchedule
+		}
+
 		data := map[string]interface{}{
 			"Key":             fn.Key,
 			"Name":            fn.Name,
@@ -284,6 +405,13 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 			"Resources":       resources,
 			"MinReplicas":     minReplicas,
 			"MaxReplicas":     maxReplicas,
+			"StackLabels":     m.stackLabels(fn.Key),
+			"Network":         m.network,
+			"FunctionLabel":   LabelFunction,
+			"Callers":         m.callerLabels(fn.Key),
+			"Routed":          m.routed(fn),
+			"Scheduling":      scheduling,
+			"Tolerations":     tolerations(scheduling.Tolerations),
 		}
 		out, err := m.executeFunction("function", fn.Key, data)
 		if err != nil {
@@ -293,6 +421,23 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 		all = append(all, out...)
 	}
 
+	if m.network.Policies && m.stack != "" {
+		data := map[string]interface{}{
+			// names can't have the capitals or underscores of a label
+			"Key":         strings.ReplaceAll(strings.ToLower(LabelValue(m.stack)), "_", "-") + "-default-deny",
+			"Namespace":   m.namespace,
+			"Commit":      m.commit,
+			"Stack":       LabelValue(m.stack),
+			"StackLabel":  LabelStack,
+			"StackLabels": m.stackLabels(""),
+		}
+		out, err := m.executeFunction("network", "default-deny", data)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, out...)
+	}
+
 	for _, secret := range secrets {
 		all = append(all, Manifest{
 			Type:    SecretManifestType,
@@ -307,23 +452,41 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 		}
 
 		fqdn := r[0].Route.FQDN
-		upstreams := make(map[string]bool)
+		key := "routes--" + name
+		policies := make(map[string]RateLimitPolicy)
 
 		for _, inner := range r {
 			if inner.Route.FQDN != fqdn {
 				return nil, ErrInvalidFQDN
 			}
 
-			upstreams[inner.Key] = true
+			if err := m.supported(inner); err != nil {
+				return nil, err
+			}
+
+			if inner.Route.RateLimit != nil {
+				p := rateLimitPolicy(key, inner.Route.RateLimit)
+				policies[p.Name] = p
+			}
+		}
+		r = dedupeSplits(r)
+
+		gateway, err := m.gateway()
+		if err != nil {
+			return nil, err
 		}
 
 		data := map[string]interface{}{
-			"Key":       "routes--" + name,
-			"Namespace": m.namespace,
-			"Commit":    m.commit,
-			"FQDN":      fqdn,
-			"Upstreams": upstreams,
-			"Routes":    r,
+			"Key":         key,
+			"Namespace":   m.namespace,
+			"Commit":      m.commit,
+			"FQDN":        fqdn,
+			"Upstreams":   upstreamTimeouts(r),
+			"Policies":    policies,
+			"Routes":      r,
+			"Router":      m.router,
+			"Gateway":     gateway,
+			"StackLabels": m.stackLabels(""),
 		}
 		out, err := m.executeFunction("proxy", name, data)
 		if err != nil {
@@ -336,21 +499,161 @@ func (m *manager) GenerateFunctions(registry string, tag string, fns []*parser.F
 	return all, nil
 }
 
-func NewManager(workingDir string, namespace string, commit string) Manager {
+// functionRoute splits the route across the release of the function, redirects
+// don't reach a function so they're left alone
+func (m *manager) functionRoute(key string, r manifests.FunctionRoute) FunctionRoute {
+	out := FunctionRoute{
+		Key:   key,
+		Route: r,
+	}
+	if release, ok := m.releases[key]; ok && r.Redirect == "" {
+		out.Splits = release.Splits
+	}
+	return out
+}
+
+// callerLabels are the function labels of the pods calling key
+func (m *manager) callerLabels(key string) []string {
+	var out []string
+	for _, caller := range m.callers[key] {
+		out = append(out, LabelValue(caller))
+	}
+	return out
+}
+
+// routed functions take requests from the router, the functions of a release
+// can be sent the requests of each other's routes
+func (m *manager) routed(fn *parser.Function) bool {
+	if _, ok := m.releases[fn.Key]; ok {
+		return true
+	}
+	for _, r := range fn.Routes {
+		if r.Redirect == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// supported when the router can express the route
+func (m *manager) supported(r FunctionRoute) error {
+	unsupported := func(what string) error {
+		return fmt.Errorf("%w: %s %s %s on %s", ErrUnsupportedRoute, r.Key, r.Route.Prefix, what, m.router.Kind)
+	}
+
+	for _, h := range r.Route.Headers {
+		switch {
+		case m.router.Kind == manifests.RouterNginx && h.Operator == manifests.HeaderRegex:
+			return unsupported("matches headers with a regex")
+		case m.router.Kind == manifests.RouterGatewayAPI && h.Operator == manifests.HeaderNotEquals:
+			return unsupported("matches headers that aren't equal")
+		}
+	}
+	if m.router.Kind == manifests.RouterGatewayAPI && r.Route.RateLimit != nil {
+		return unsupported("rate limits")
+	}
+
+	if m.router.Kind != manifests.RouterIngress {
+		return nil
+	}
+	switch {
+	case r.Route.Redirect != "":
+		return unsupported("redirects")
+	case len(r.Splits) > 0:
+		return unsupported("splits")
+	case len(r.Route.Headers) > 0 || len(r.Route.Methods) > 0:
+		return unsupported("matches headers or methods")
+	case r.Route.Rewrite != "" || r.Route.Timeout != "" || r.Route.CORS != nil || r.Route.RateLimit != nil:
+		return unsupported("has a rewrite, timeout, cors or rate limit")
+	}
+	return nil
+}
+
+// gateway the HTTPRoutes attach to, the namespace is the one deployed to
+// unless it's given
+func (m *manager) gateway() (*GatewayRef, error) {
+	if m.router.Kind != manifests.RouterGatewayAPI {
+		return nil, nil
+	}
+	if m.router.Gateway == "" {
+		return nil, ErrNoGateway
+	}
+	ref := &GatewayRef{Namespace: m.namespace, Name: m.router.Gateway}
+	if i := strings.Index(m.router.Gateway, "/"); i > -1 {
+		ref.Namespace = m.router.Gateway[:i]
+		ref.Name = m.router.Gateway[i+1:]
+	}
+	return ref, nil
+}
+
+// dedupeSplits keeps one route per prefix for the functions of a release, they
+// all split the same way
+func dedupeSplits(routes []FunctionRoute) []FunctionRoute {
+	var out []FunctionRoute
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if len(r.Splits) > 0 {
+			id := r.Splits[0].Function + " " + r.Route.Prefix
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func NewManager(opts Options) Manager {
 	namespacePrefix := ""
 	routesPrefix := ""
-	indexOf := strings.Index(namespace, "--")
+	indexOf := strings.Index(opts.Namespace, "--")
 	if indexOf > -1 {
-		namespacePrefix = namespace[0 : indexOf+2]
-		routesPrefix = namespace[indexOf+2:] + "--"
+		namespacePrefix = opts.Namespace[0 : indexOf+2]
+		routesPrefix = opts.Namespace[indexOf+2:] + "--"
 	}
 
 	funcMap := GetFuncMaps(namespacePrefix, routesPrefix)
 
+	router := opts.Router
+	if router.Kind == "" {
+		router.Kind = manifests.RouterNginx
+	}
+	if router.Issuer == "" {
+		router.Issuer = "letsencrypt"
+	}
+	if router.IssuerKind == "" {
+		router.IssuerKind = "ClusterIssuer"
+	}
+	if router.Class == "" && router.Kind == manifests.RouterGatewayAPI {
+		// the class Envoy Gateway installs
+		router.Class = "eg"
+	}
+
+	network := opts.Network
+	if network.IngressNamespace == "" {
+		network.IngressNamespace = ingressNamespaces[router.Kind]
+	}
+	if network.MetricsNamespace == "" {
+		network.MetricsNamespace = "monitoring"
+	}
+
+	releases := make(map[string]*parser.Release)
+	for _, r := range opts.Releases {
+		for _, split := range r.Splits {
+			releases[split.Function] = r
+		}
+	}
+
 	return &manager{
-		workingDir: workingDir,
-		namespace:  namespace,
-		commit:     commit,
+		workingDir: opts.WorkingDir,
+		namespace:  opts.Namespace,
+		commit:     opts.Commit,
+		stack:      opts.Stack,
+		releases:   releases,
+		router:     router,
+		network:    network,
+		callers:    opts.Callers,
 		funcMap:    funcMap,
 	}
 }
@@ -1,9 +1,15 @@
 package deployer
 
 import (
+	"errors"
+	"os"
 	"path"
+	"strings"
 	"testing"
 
+	"gopkg.in/yaml.v2"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
 	"github.com/contextcloud/ccb/pkg/parser"
 )
 
@@ -16,7 +22,12 @@ func Test_Build(t *testing.T) {
 		return
 	}
 
-	manager := NewManager("./example", "default", "v1")
+	manager := NewManager(Options{
+		WorkingDir: "./example",
+		Namespace:  "default",
+		Commit:     "v1",
+		Stack:      stack.GetName(),
+	})
 	fns, err := stack.GetFunctions()
 	if err != nil {
 		t.Error(err)
@@ -41,7 +52,12 @@ func Test_Routes(t *testing.T) {
 		return
 	}
 
-	manager := NewManager("./example", "default", "v1")
+	manager := NewManager(Options{
+		WorkingDir: "./example",
+		Namespace:  "default",
+		Commit:     "v1",
+		Stack:      stack.GetName(),
+	})
 	rts, err := stack.GetRoutes()
 	if err != nil {
 		t.Error(err)
@@ -56,3 +72,673 @@ func Test_Routes(t *testing.T) {
 
 	t.Log(manifests.merged())
 }
+
+func Test_FunctionEnvironment(t *testing.T) {
+	fn := &parser.Function{
+		Key: "api",
+	}
+	fn.Version = "0.1"
+	fn.Envs = []string{".env/common"}
+	fn.Env = map[string]string{"demo": "no"}
+
+	env, err := FunctionEnvironment("./example", fn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if env["demo"] != "no" || env["SERVICENAME"] != "api" || env["VERSION"] != "0.1" {
+		t.Errorf("Invalid env: %v", env)
+	}
+}
+
+func Test_StackLabels(t *testing.T) {
+	stack, err := parser.LoadStack(path.Join("./example", "stack.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stack.GetName() != "example" {
+		t.Errorf("expected the stack to be named after its directory, got %s", stack.GetName())
+		return
+	}
+	if stack.IsNamed() {
+		t.Error("expected a name from the directory not to identify the stack")
+		return
+	}
+
+	fns, err := stack.GetFunctions("profile")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	manager := NewManager(Options{
+		WorkingDir: "./example",
+		Namespace:  "default",
+		Stack:      "shop api",
+	})
+	manifests, err := manager.GenerateFunctions("", "latest", fns)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, m := range manifests {
+		var obj struct {
+			Kind     string
+			Metadata struct {
+				Labels map[string]string
+			}
+			Spec struct {
+				Selector map[string]interface{}
+			}
+		}
+		if err := yaml.Unmarshal([]byte(m.Content), &obj); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// proxies are shared by the functions of a route
+		function := "profile"
+		if m.Type == ProxyManifestType {
+			function = ""
+		}
+		if obj.Metadata.Labels[LabelStack] != "shop-api" || obj.Metadata.Labels[LabelFunction] != function {
+			t.Errorf("%s: missing stack labels %v", obj.Kind, obj.Metadata.Labels)
+		}
+
+		selector, _ := yaml.Marshal(obj.Spec.Selector)
+		if strings.Contains(string(selector), "ccb.contextcloud.dev") {
+			t.Errorf("%s: stack labels in the selector %s", obj.Kind, selector)
+		}
+	}
+}
+
+func Test_Releases(t *testing.T) {
+	stack, err := parser.LoadStack(path.Join("./example", "stack.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions("assets_*")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	releases, err := stack.GetReleases()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	manager := NewManager(Options{
+		WorkingDir: "./example",
+		Namespace:  "default",
+		Releases:   releases,
+	})
+	manifests, err := manager.GenerateFunctions("", "latest", fns)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	proxies := 0
+	for _, m := range manifests {
+		if m.Type != ProxyManifestType {
+			continue
+		}
+		proxies++
+
+		var obj struct {
+			Spec struct {
+				Upstreams []struct {
+					Name string
+				}
+				Subroutes []struct {
+					Path   string
+					Splits []struct {
+						Weight int
+						Action struct {
+							Pass string
+						}
+					}
+				}
+			}
+		}
+		if err := yaml.Unmarshal([]byte(m.Content), &obj); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(obj.Spec.Upstreams) != 2 {
+			t.Errorf("%s: expected both versions as upstreams, got %v", m.Key, obj.Spec.Upstreams)
+		}
+		if len(obj.Spec.Subroutes) != 1 {
+			t.Errorf("%s: expected one subroute, got %d", m.Key, len(obj.Spec.Subroutes))
+			continue
+		}
+		splits := obj.Spec.Subroutes[0].Splits
+		if len(splits) != 2 || splits[0].Action.Pass != "assets_1" || splits[0].Weight != 90 || splits[1].Action.Pass != "assets_2" || splits[1].Weight != 10 {
+			t.Errorf("%s: unexpected splits %+v", m.Key, splits)
+		}
+	}
+	if proxies != 2 {
+		t.Errorf("expected 2 proxies, got %d", proxies)
+	}
+}
+
+func Test_Routers(t *testing.T) {
+	stack, err := parser.LoadStack(path.Join("./example", "stack.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions("assets_1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rts, err := stack.GetRoutes()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kinds := func(all Manifests) []string {
+		var out []string
+		for _, m := range all {
+			var obj struct {
+				Kind string
+			}
+			if err := yaml.Unmarshal([]byte(m.Content), &obj); err != nil {
+				t.Error(err)
+			}
+			out = append(out, obj.Kind)
+		}
+		return out
+	}
+
+	tests := []struct {
+		router    manifests.Router
+		routes    string
+		functions string
+	}{
+		{manifests.Router{}, "Certificate VirtualServer", "Deployment HorizontalPodAutoscaler PodDisruptionBudget Service VirtualServerRoute"},
+		{manifests.Router{Kind: manifests.RouterGatewayAPI, Gateway: "gateways/public"}, "Certificate Gateway HTTPRoute HTTPRoute", "Deployment HorizontalPodAutoscaler PodDisruptionBudget Service HTTPRoute"},
+		{manifests.Router{Kind: manifests.RouterIngress}, "", "Deployment HorizontalPodAutoscaler PodDisruptionBudget Service Ingress"},
+	}
+	for _, test := range tests {
+		manager := NewManager(Options{
+			WorkingDir: "./example",
+			Namespace:  "default",
+			Router:     test.router,
+		})
+
+		out, err := manager.GenerateFunctions("", "latest", fns)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := strings.Join(kinds(out), " "); got != test.functions {
+			t.Errorf("%s: expected %s, got %s", test.router.Kind, test.functions, got)
+		}
+
+		out, err = manager.GenerateRoutes(rts)
+		if test.router.Kind == manifests.RouterIngress {
+			// ingress can't redirect
+			if !errors.Is(err, ErrUnsupportedRoute) {
+				t.Errorf("expected ErrUnsupportedRoute, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := strings.Join(kinds(out), " "); got != test.routes {
+			t.Errorf("%s: expected %s, got %s", test.router.Kind, test.routes, got)
+		}
+	}
+
+	manager := NewManager(Options{
+		WorkingDir: "./example",
+		Router:     manifests.Router{Kind: manifests.RouterGatewayAPI},
+	})
+	if _, err := manager.GenerateFunctions("", "latest", fns); !errors.Is(err, ErrNoGateway) {
+		t.Errorf("expected ErrNoGateway, got %v", err)
+	}
+
+	// ingress only has the paths of this namespace
+	include := &parser.Route{Key: "democom"}
+	include.FQDN = "demo.com"
+	include.Routes = []manifests.RouteInclude{{Name: "api", Namespace: "other", Prefix: "/other"}}
+	ingress := NewManager(Options{Router: manifests.Router{Kind: manifests.RouterIngress}})
+	if _, err := ingress.GenerateRoutes([]*parser.Route{include}); !errors.Is(err, ErrUnsupportedRoute) {
+		t.Errorf("expected ErrUnsupportedRoute for a namespace include, got %v", err)
+	}
+	include.Routes[0].Namespace = ""
+	if _, err := ingress.GenerateRoutes([]*parser.Route{include}); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_RouteOptions(t *testing.T) {
+	const stackFile = `provider:
+  version: 0.2
+
+functions:
+  api:
+    version: 0.1
+    routes:
+      - name: api
+        fqdn: demo.com
+        prefix: /api
+        rewrite: /
+        timeout: 30s
+        methods: [GET, POST]
+        headers:
+          - name: X-Tenant
+            value: demo
+        cors:
+          origin: https://app.demo.com
+          credentials: true
+        rate_limit:
+          rate: 10
+          burst: 20
+`
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte(stackFile), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	stack, err := parser.LoadStack(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	render := func(router manifests.Router) map[ManifestType]string {
+		out, err := NewManager(Options{Namespace: "default", Router: router}).GenerateFunctions("", "latest", fns)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		byType := make(map[ManifestType]string)
+		for _, m := range out {
+			byType[m.Type] = m.Content
+		}
+		return byType
+	}
+
+	nginx := render(manifests.Router{})
+	var vsr struct {
+		Spec struct {
+			Upstreams []struct {
+				ReadTimeout string `yaml:"read-timeout"`
+			}
+			Subroutes []struct {
+				Policies []struct {
+					Name string
+				}
+				Matches []struct {
+					Conditions []map[string]string
+					Action     struct {
+						Proxy struct {
+							Upstream        string
+							RewritePath     string `yaml:"rewritePath"`
+							ResponseHeaders struct {
+								Add []struct {
+									Name  string
+									Value string
+								}
+							} `yaml:"responseHeaders"`
+						}
+					}
+				}
+				Action struct {
+					Return struct {
+						Code int
+					}
+				}
+			}
+		}
+	}
+	if err := yaml.Unmarshal([]byte(nginx[ProxyManifestType]), &vsr); err != nil {
+		t.Error(err)
+		return
+	}
+	if vsr.Spec.Upstreams[0].ReadTimeout != "30s" {
+		t.Errorf("expected the timeout on the upstream, got %s", vsr.Spec.Upstreams[0].ReadTimeout)
+	}
+	sub := vsr.Spec.Subroutes[0]
+	if len(sub.Policies) != 1 || sub.Policies[0].Name != "routes--api-rate-10-20" {
+		t.Errorf("unexpected policies %v", sub.Policies)
+	}
+	if !strings.Contains(nginx[PolicyManifestType], "rate: 10r/s") {
+		t.Errorf("unexpected policy %s", nginx[PolicyManifestType])
+	}
+	if len(sub.Matches) != 2 || len(sub.Matches[1].Conditions) != 2 || sub.Matches[1].Conditions[1]["value"] != "POST" {
+		t.Errorf("expected a match for each method, got %+v", sub.Matches)
+		return
+	}
+	proxy := sub.Matches[0].Action.Proxy
+	if proxy.Upstream != "api" || proxy.RewritePath != "/" || len(proxy.ResponseHeaders.Add) != 3 {
+		t.Errorf("unexpected proxy %+v", proxy)
+	}
+	if sub.Action.Return.Code != 404 {
+		t.Errorf("expected a 404 when nothing matches, got %d", sub.Action.Return.Code)
+	}
+
+	// gateway api has no rate limits
+	if _, err := NewManager(Options{Router: manifests.Router{Kind: manifests.RouterGatewayAPI, Gateway: "public"}}).GenerateFunctions("", "latest", fns); !errors.Is(err, ErrUnsupportedRoute) {
+		t.Errorf("expected ErrUnsupportedRoute, got %v", err)
+	}
+	fns[0].Routes[0].RateLimit = nil
+
+	gateway := render(manifests.Router{Kind: manifests.RouterGatewayAPI, Gateway: "public"})
+	var route struct {
+		Spec struct {
+			Rules []struct {
+				Matches []struct {
+					Method  string
+					Headers []map[string]string
+				}
+				Filters []struct {
+					Type string
+				}
+				Timeouts struct {
+					Request string
+				}
+			}
+		}
+	}
+	if err := yaml.Unmarshal([]byte(gateway[ProxyManifestType]), &route); err != nil {
+		t.Error(err)
+		return
+	}
+	rule := route.Spec.Rules[0]
+	if len(rule.Matches) != 2 || rule.Matches[0].Method != "GET" || rule.Matches[0].Headers[0]["type"] != "Exact" {
+		t.Errorf("unexpected matches %+v", rule.Matches)
+	}
+	if len(rule.Filters) != 2 || rule.Filters[0].Type != "URLRewrite" || rule.Filters[1].Type != "ResponseHeaderModifier" {
+		t.Errorf("unexpected filters %+v", rule.Filters)
+	}
+	if rule.Timeouts.Request != "30s" {
+		t.Errorf("unexpected timeout %s", rule.Timeouts.Request)
+	}
+}
+
+func Test_NetworkPolicies(t *testing.T) {
+	const stackFile = `name: demo
+
+provider:
+  version: 0.2
+
+network:
+  policies: true
+
+functions:
+  api:
+    version: 0.1
+    calls: [worker]
+    routes:
+      - name: api
+        fqdn: demo.com
+        prefix: /api
+  worker:
+    version: 0.1
+`
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte(stackFile), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	stack, err := parser.LoadStack(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := NewManager(Options{
+		Namespace: "default",
+		Stack:     stack.GetName(),
+		Network:   stack.GetNetwork(),
+		Callers:   stack.GetCallers(),
+	}).GenerateFunctions("", "latest", fns)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type policy struct {
+		Metadata struct {
+			Name string
+		}
+		Spec struct {
+			Ingress []struct {
+				From []struct {
+					PodSelector struct {
+						MatchLabels map[string]string `yaml:"matchLabels"`
+					} `yaml:"podSelector"`
+					NamespaceSelector struct {
+						MatchLabels map[string]string `yaml:"matchLabels"`
+					} `yaml:"namespaceSelector"`
+				}
+			}
+		}
+	}
+	policies := make(map[string]policy)
+	for _, m := range out {
+		if m.Type != NetworkPolicyType {
+			continue
+		}
+		var p policy
+		if err := yaml.Unmarshal([]byte(m.Content), &p); err != nil {
+			t.Error(err)
+			return
+		}
+		policies[p.Metadata.Name] = p
+	}
+
+	if _, ok := policies["demo-default-deny"]; !ok || len(policies) != 3 {
+		t.Errorf("expected a default deny and a policy for each function, got %v", policies)
+		return
+	}
+
+	// the router and the metrics scraper
+	api := policies["api"].Spec.Ingress
+	if len(api) != 2 || api[0].From[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "nginx-ingress" {
+		t.Errorf("unexpected api ingress %+v", api)
+	}
+
+	// api and the metrics scraper
+	worker := policies["worker"].Spec.Ingress
+	if len(worker) != 2 || worker[0].From[0].PodSelector.MatchLabels[LabelFunction] != "api" {
+		t.Errorf("unexpected worker ingress %+v", worker)
+	}
+	if worker[1].From[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "monitoring" {
+		t.Errorf("unexpected metrics ingress %+v", worker[1])
+	}
+	invalid := strings.Replace(stackFile, "calls: [worker]", "calls: [jobs]", 1)
+	if err := os.WriteFile(filename, []byte(invalid), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := parser.LoadStack(filename); !errors.Is(err, parser.ErrInvalidCall) {
+		t.Errorf("expected ErrInvalidCall, got %v", err)
+	}
+}
+
+func Test_Scheduling(t *testing.T) {
+	const stackFile = `provider:
+  version: 0.2
+
+scheduling:
+  node_selector:
+    cloud.google.com/gke-spot: "true"
+  tolerations:
+    - key: spot
+      operator: Exists
+      effect: NoSchedule
+  spread: DoNotSchedule
+
+functions:
+  api:
+    version: 0.1
+    min_replicas: 3
+  worker:
+    version: 0.1
+    min_replicas: 1
+    scheduling:
+      node_selector: {}
+      spread: none
+      priority_class: low
+      affinity:
+        nodeAffinity:
+          preferredDuringSchedulingIgnoredDuringExecution:
+            - weight: 1
+              preference:
+                matchExpressions:
+                  - key: pool
+                    operator: In
+                    values: [batch]
+`
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte(stackFile), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	stack, err := parser.LoadStack(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := NewManager(Options{Namespace: "default"}).GenerateFunctions("", "latest", fns)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	type deployment struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					NodeSelector map[string]string `yaml:"nodeSelector"`
+					Tolerations  []map[string]string
+					Affinity     map[string]interface{}
+					Priority     string `yaml:"priorityClassName"`
+					Spread       []struct {
+						WhenUnsatisfiable string `yaml:"whenUnsatisfiable"`
+					} `yaml:"topologySpreadConstraints"`
+				}
+			}
+		}
+	}
+	deployments := make(map[string]deployment)
+	budgets := make(map[string]string)
+	for _, m := range out {
+		switch m.Type {
+		case DeploymentManifestType:
+			var d deployment
+			if err := yaml.Unmarshal([]byte(m.Content), &d); err != nil {
+				t.Error(err)
+				return
+			}
+			deployments[m.Key] = d
+		case DisruptionBudgetType:
+			budgets[m.Key] = m.Content
+		}
+	}
+
+	// the stack defaults
+	api := deployments["api"].Spec.Template.Spec
+	if api.NodeSelector["cloud.google.com/gke-spot"] != "true" || len(api.Tolerations) != 1 || api.Tolerations[0]["operator"] != "Exists" {
+		t.Errorf("expected the stack defaults, got %+v", api)
+	}
+	if len(api.Spread) != 1 || api.Spread[0].WhenUnsatisfiable != manifests.SpreadDoNotSchedule {
+		t.Errorf("unexpected spread %+v", api.Spread)
+	}
+
+	// the function's own
+	worker := deployments["worker"].Spec.Template.Spec
+	if len(worker.NodeSelector) != 0 || len(worker.Spread) != 0 || worker.Priority != "low" || worker.Affinity["nodeAffinity"] == nil {
+		t.Errorf("expected the function settings, got %+v", worker)
+	}
+
+	if !strings.Contains(budgets["api"], "minAvailable: 2") {
+		t.Errorf("unexpected budget %s", budgets["api"])
+	}
+	if _, ok := budgets["worker"]; ok {
+		t.Errorf("a single replica can't have a budget")
+	}
+
+	// without any scheduling they're rendered like they always were
+	const baseline = `      nodeSelector:
+        cloud.google.com/gke-spot: "true"
+      topologySpreadConstraints:
+      - maxSkew: 1
+        topologyKey: kubernetes.io/hostname
+        whenUnsatisfiable: DoNotSchedule
+        labelSelector:
+          matchLabels:
+            release: api
+            version: "0.1"
+            environment: 
+            commit: ""
+      terminationGracePeriodSeconds: 25
+`
+	const defaults = `provider:
+  version: 0.2
+
+functions:
+  api:
+    version: 0.1
+`
+	if err := os.WriteFile(filename, []byte(defaults), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if stack, err = parser.LoadStack(filename); err != nil {
+		t.Error(err)
+		return
+	}
+	if fns, err = stack.GetFunctions(); err != nil {
+		t.Error(err)
+		return
+	}
+	if out, err = NewManager(Options{Namespace: "default"}).GenerateFunctions("", "latest", fns); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, m := range out {
+		if m.Type == DeploymentManifestType && !strings.Contains(m.Content, baseline) {
+			t.Errorf("expected the baseline scheduling, got %s", m.Content)
+		}
+	}
+
+	invalid := strings.Replace(stackFile, "spread: none", "spread: evenly", 1)
+	if err := os.WriteFile(filename, []byte(invalid), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := parser.LoadStack(filename); !errors.Is(err, parser.ErrInvalidScheduling) {
+		t.Errorf("expected ErrInvalidScheduling, got %v", err)
+	}
+}
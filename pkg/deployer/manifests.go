@@ -2,6 +2,7 @@ package deployer
 
 import (
 	"os"
+	"strings"
 
 	"github.com/contextcloud/ccb/pkg/print"
 )
@@ -15,20 +16,35 @@ var (
 	ProxyManifestType       ManifestType = "Proxy"
 	CertificateManifestType ManifestType = "Certificate"
 	VirtualServerType       ManifestType = "VirtualServer"
+	GatewayManifestType     ManifestType = "Gateway"
+	HTTPRouteManifestType   ManifestType = "HTTPRoute"
+	PolicyManifestType      ManifestType = "Policy"
+	NetworkPolicyType       ManifestType = "NetworkPolicy"
+	DisruptionBudgetType    ManifestType = "PodDisruptionBudget"
 )
 
 func ToManifestType(p string) ManifestType {
-	switch p {
+	switch strings.TrimPrefix(p, "templates/") {
 	case "function/deployment.yaml":
 		return DeploymentManifestType
 	case "function/service.yaml":
 		return ServiceManifestType
-	case "proxy/proxy.yaml":
+	case "function/pdb.yaml":
+		return DisruptionBudgetType
+	case "function/networkpolicy.yaml", "network/deny.yaml":
+		return NetworkPolicyType
+	case "proxy/proxy.yaml", "proxy/httproute.yaml", "proxy/ingress.yaml":
 		return ProxyManifestType
+	case "proxy/policy.yaml":
+		return PolicyManifestType
 	case "routes/certificate.yaml":
 		return CertificateManifestType
 	case "routes/server.yaml":
 		return VirtualServerType
+	case "routes/gateway.yaml":
+		return GatewayManifestType
+	case "routes/httproute.yaml", "routes/redirect.yaml":
+		return HTTPRouteManifestType
 	default:
 		return ""
 	}
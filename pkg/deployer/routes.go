@@ -0,0 +1,330 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+// defaultTimeout of the upstreams when the routes don't set one
+const defaultTimeout = "300s"
+
+// RateLimitPolicy is an nginx policy routes with the same limits share
+type RateLimitPolicy struct {
+	Name  string
+	Rate  int
+	Burst int
+}
+
+// rateLimitPolicy is named after its limits so routes can share it
+func rateLimitPolicy(key string, rl *manifests.RouteRateLimit) RateLimitPolicy {
+	return RateLimitPolicy{
+		Name:  fmt.Sprintf("%s-rate-%d-%d", key, rl.Rate, rl.Burst),
+		Rate:  rl.Rate,
+		Burst: rl.Burst,
+	}
+}
+
+// upstreamTimeouts are the longest timeout of the routes to each function,
+// nginx sets them on the upstream instead of the route
+func upstreamTimeouts(routes []FunctionRoute) map[string]string {
+	longest := make(map[string]time.Duration)
+	out := make(map[string]string)
+	set := func(key string, timeout string) {
+		if _, ok := out[key]; !ok {
+			out[key] = defaultTimeout
+		}
+		if timeout == "" {
+			return
+		}
+		// the parser checked it
+		d, _ := time.ParseDuration(timeout)
+		if d > longest[key] {
+			longest[key] = d
+			out[key] = timeout
+		}
+	}
+
+	for _, r := range routes {
+		set(r.Key, r.Route.Timeout)
+		for _, split := range r.Splits {
+			set(split.Function, r.Route.Timeout)
+		}
+	}
+	return out
+}
+
+// corsHeaders are added to every response of the route
+func corsHeaders(cors *manifests.RouteCORS) [][2]string {
+	if cors == nil {
+		return nil
+	}
+
+	methods := cors.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+
+	out := [][2]string{
+		{"Access-Control-Allow-Origin", cors.Origin},
+		{"Access-Control-Allow-Methods", strings.Join(methods, ", ")},
+	}
+	if len(cors.Headers) > 0 {
+		out = append(out, [2]string{"Access-Control-Allow-Headers", strings.Join(cors.Headers, ", ")})
+	}
+	if cors.Credentials {
+		out = append(out, [2]string{"Access-Control-Allow-Credentials", "true"})
+	}
+	if cors.MaxAge > 0 {
+		out = append(out, [2]string{"Access-Control-Max-Age", fmt.Sprint(cors.MaxAge)})
+	}
+	return out
+}
+
+// nginxSubroutes of a VirtualServerRoute, key is the name of it
+func nginxSubroutes(key string, routes []FunctionRoute) []yaml.MapSlice {
+	var out []yaml.MapSlice
+	for _, r := range routes {
+		out = append(out, nginxSubroute(key, r))
+	}
+	return out
+}
+
+func nginxSubroute(key string, r FunctionRoute) yaml.MapSlice {
+	out := yaml.MapSlice{{Key: "path", Value: r.Route.Prefix}}
+	if r.Route.RateLimit != nil {
+		out = append(out, yaml.MapItem{Key: "policies", Value: []yaml.MapSlice{
+			{{Key: "name", Value: rateLimitPolicy(key, r.Route.RateLimit).Name}},
+		}})
+	}
+
+	target := nginxTarget(r)
+	conditions := nginxConditions(r.Route)
+	if len(conditions) == 0 {
+		return append(out, target...)
+	}
+
+	var matches []yaml.MapSlice
+	for _, c := range conditions {
+		matches = append(matches, append(yaml.MapSlice{{Key: "conditions", Value: c}}, target...))
+	}
+	out = append(out, yaml.MapItem{Key: "matches", Value: matches})
+
+	// nothing matched, the route is there but not for this request
+	code, body := 404, "Not Found"
+	if len(r.Route.Headers) == 0 {
+		code, body = 405, "Method Not Allowed"
+	}
+	return append(out, yaml.MapItem{Key: "action", Value: yaml.MapSlice{
+		{Key: "return", Value: yaml.MapSlice{
+			{Key: "code", Value: code},
+			{Key: "type", Value: "text/plain"},
+			{Key: "body", Value: body},
+		}},
+	}})
+}
+
+// nginxConditions are all of the headers with each method, nginx conditions
+// only take one value
+func nginxConditions(r manifests.FunctionRoute) [][]yaml.MapSlice {
+	var headers []yaml.MapSlice
+	for _, h := range r.Headers {
+		value := h.Value
+		if h.Operator == manifests.HeaderNotEquals {
+			value = "!" + value
+		}
+		headers = append(headers, yaml.MapSlice{
+			{Key: "header", Value: h.Name},
+			{Key: "value", Value: value},
+		})
+	}
+
+	if len(r.Methods) == 0 {
+		if len(headers) == 0 {
+			return nil
+		}
+		return [][]yaml.MapSlice{headers}
+	}
+
+	var out [][]yaml.MapSlice
+	for _, m := range r.Methods {
+		c := append([]yaml.MapSlice{}, headers...)
+		c = append(c, yaml.MapSlice{
+			{Key: "variable", Value: "$request_method"},
+			{Key: "value", Value: m},
+		})
+		out = append(out, c)
+	}
+	return out
+}
+
+// nginxTarget is the action of a route or the splits of its release
+func nginxTarget(r FunctionRoute) yaml.MapSlice {
+	if len(r.Splits) == 0 {
+		return yaml.MapSlice{{Key: "action", Value: nginxAction(r.Route, r.Key)}}
+	}
+
+	var splits []yaml.MapSlice
+	for _, s := range r.Splits {
+		splits = append(splits, yaml.MapSlice{
+			{Key: "weight", Value: s.Weight},
+			{Key: "action", Value: nginxAction(r.Route, s.Function)},
+		})
+	}
+	return yaml.MapSlice{{Key: "splits", Value: splits}}
+}
+
+func nginxAction(r manifests.FunctionRoute, upstream string) yaml.MapSlice {
+	if r.Redirect != "" {
+		return yaml.MapSlice{{Key: "redirect", Value: yaml.MapSlice{
+			{Key: "url", Value: r.Redirect},
+			{Key: "code", Value: 301},
+		}}}
+	}
+	if r.Rewrite == "" && r.CORS == nil {
+		return yaml.MapSlice{{Key: "pass", Value: upstream}}
+	}
+
+	proxy := yaml.MapSlice{{Key: "upstream", Value: upstream}}
+	if r.Rewrite != "" {
+		proxy = append(proxy, yaml.MapItem{Key: "rewritePath", Value: r.Rewrite})
+	}
+	if headers := corsHeaders(r.CORS); len(headers) > 0 {
+		var add []yaml.MapSlice
+		for _, h := range headers {
+			add = append(add, yaml.MapSlice{
+				{Key: "name", Value: h[0]},
+				{Key: "value", Value: h[1]},
+				{Key: "always", Value: true},
+			})
+		}
+		proxy = append(proxy, yaml.MapItem{Key: "responseHeaders", Value: yaml.MapSlice{{Key: "add", Value: add}}})
+	}
+	return yaml.MapSlice{{Key: "proxy", Value: proxy}}
+}
+
+// gatewayRules of an HTTPRoute
+func gatewayRules(routes []FunctionRoute) []yaml.MapSlice {
+	var out []yaml.MapSlice
+	for _, r := range routes {
+		out = append(out, gatewayRule(r))
+	}
+	return out
+}
+
+func gatewayRule(r FunctionRoute) yaml.MapSlice {
+	path := yaml.MapSlice{
+		{Key: "type", Value: "PathPrefix"},
+		{Key: "value", Value: r.Route.Prefix},
+	}
+	var headers []yaml.MapSlice
+	for _, h := range r.Route.Headers {
+		kind := "Exact"
+		if h.Operator == manifests.HeaderRegex {
+			kind = "RegularExpression"
+		}
+		headers = append(headers, yaml.MapSlice{
+			{Key: "type", Value: kind},
+			{Key: "name", Value: h.Name},
+			{Key: "value", Value: h.Value},
+		})
+	}
+	match := func(method string) yaml.MapSlice {
+		m := yaml.MapSlice{{Key: "path", Value: path}}
+		if len(headers) > 0 {
+			m = append(m, yaml.MapItem{Key: "headers", Value: headers})
+		}
+		if method != "" {
+			m = append(m, yaml.MapItem{Key: "method", Value: method})
+		}
+		return m
+	}
+
+	var matches []yaml.MapSlice
+	if len(r.Route.Methods) == 0 {
+		matches = append(matches, match(""))
+	}
+	for _, m := range r.Route.Methods {
+		matches = append(matches, match(m))
+	}
+	out := yaml.MapSlice{{Key: "matches", Value: matches}}
+
+	var filters []yaml.MapSlice
+	if r.Route.Redirect != "" {
+		redirect := ParseRedirect(r.Route.Redirect)
+		params := yaml.MapSlice{}
+		if redirect.Scheme != "" {
+			params = append(params, yaml.MapItem{Key: "scheme", Value: redirect.Scheme})
+		}
+		if redirect.Hostname != "" {
+			params = append(params, yaml.MapItem{Key: "hostname", Value: redirect.Hostname})
+		}
+		if redirect.Path != "" {
+			params = append(params, yaml.MapItem{Key: "path", Value: yaml.MapSlice{
+				{Key: "type", Value: "ReplaceFullPath"},
+				{Key: "replaceFullPath", Value: redirect.Path},
+			}})
+		}
+		params = append(params, yaml.MapItem{Key: "statusCode", Value: 301})
+		filters = append(filters, yaml.MapSlice{
+			{Key: "type", Value: "RequestRedirect"},
+			{Key: "requestRedirect", Value: params},
+		})
+	}
+	if r.Route.Rewrite != "" {
+		filters = append(filters, yaml.MapSlice{
+			{Key: "type", Value: "URLRewrite"},
+			{Key: "urlRewrite", Value: yaml.MapSlice{
+				{Key: "path", Value: yaml.MapSlice{
+					{Key: "type", Value: "ReplacePrefixMatch"},
+					{Key: "replacePrefixMatch", Value: r.Route.Rewrite},
+				}},
+			}},
+		})
+	}
+	if headers := corsHeaders(r.Route.CORS); len(headers) > 0 {
+		var add []yaml.MapSlice
+		for _, h := range headers {
+			add = append(add, yaml.MapSlice{
+				{Key: "name", Value: h[0]},
+				{Key: "value", Value: h[1]},
+			})
+		}
+		filters = append(filters, yaml.MapSlice{
+			{Key: "type", Value: "ResponseHeaderModifier"},
+			{Key: "responseHeaderModifier", Value: yaml.MapSlice{{Key: "add", Value: add}}},
+		})
+	}
+	if len(filters) > 0 {
+		out = append(out, yaml.MapItem{Key: "filters", Value: filters})
+	}
+
+	if r.Route.Redirect != "" {
+		return out
+	}
+
+	var backends []yaml.MapSlice
+	if len(r.Splits) == 0 {
+		backends = append(backends, yaml.MapSlice{
+			{Key: "name", Value: r.Key},
+			{Key: "port", Value: 8080},
+		})
+	}
+	for _, s := range r.Splits {
+		backends = append(backends, yaml.MapSlice{
+			{Key: "name", Value: s.Function},
+			{Key: "port", Value: 8080},
+			{Key: "weight", Value: s.Weight},
+		})
+	}
+	out = append(out, yaml.MapItem{Key: "backendRefs", Value: backends})
+
+	if r.Route.Timeout != "" {
+		out = append(out, yaml.MapItem{Key: "timeouts", Value: yaml.MapSlice{{Key: "request", Value: r.Route.Timeout}}})
+	}
+	return out
+}
@@ -34,6 +34,15 @@ type Secret struct {
 type FunctionRoute struct {
 	Key   string
 	Route manifests.FunctionRoute
+	// Splits when the function is in a release, the route sends traffic to
+	// every function of it
+	Splits []manifests.ReleaseSplit
+}
+
+// GatewayRef is the gateway HTTPRoutes attach to
+type GatewayRef struct {
+	Namespace string
+	Name      string
 }
 
 // KubeSecret for parsing secret files
@@ -42,6 +51,13 @@ type KubeSecret struct {
 	Metadata *KubeMetadata `yaml:"metadata"`
 }
 
+// KubeSecretData for reading the values of a plain secret
+type KubeSecretData struct {
+	Kind       string            `yaml:"kind"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
 // KubeMetadata basic metadata for a K8 manifest
 type KubeMetadata struct {
 	Name      string `yaml:"name"`
@@ -1,13 +1,17 @@
 package deployer
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
 	"gopkg.in/yaml.v2"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
 )
 
 func ImageName(registry, imageName, tag string) string {
@@ -28,6 +32,75 @@ func ImageName(registry, imageName, tag string) string {
 	return tagger()
 }
 
+// Redirect is a redirect url split up for routers that take it in parts
+type Redirect struct {
+	Scheme   string
+	Hostname string
+	Path     string
+}
+
+// ParseRedirect splits up a redirect, it's a url, a host with an optional
+// path or only a path
+func ParseRedirect(v string) Redirect {
+	if strings.Contains(v, "://") {
+		if u, err := url.Parse(v); err == nil {
+			return Redirect{Scheme: u.Scheme, Hostname: u.Hostname(), Path: u.Path}
+		}
+	}
+	if strings.HasPrefix(v, "/") {
+		return Redirect{Path: v}
+	}
+	if i := strings.Index(v, "/"); i > -1 {
+		return Redirect{Hostname: v[:i], Path: v[i:]}
+	}
+	return Redirect{Hostname: v}
+}
+
+// LabelValue makes a name safe to use as a label value, anything that isn't
+// alphanumeric, '-', '_' or '.' becomes '-'
+func LabelValue(v string) string {
+	out := []byte(v)
+	for i, c := range out {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			out[i] = '-'
+		}
+	}
+	if len(out) > 63 {
+		out = out[:63]
+	}
+	return strings.Trim(string(out), "-_.")
+}
+
+// tolerations as the pod spec takes them
+func tolerations(in []manifests.Toleration) []yaml.MapSlice {
+	var out []yaml.MapSlice
+	for _, t := range in {
+		var item yaml.MapSlice
+		add := func(key string, value interface{}) {
+			item = append(item, yaml.MapItem{Key: key, Value: value})
+		}
+		if t.Key != "" {
+			add("key", t.Key)
+		}
+		if t.Operator != "" {
+			add("operator", t.Operator)
+		}
+		if t.Value != "" {
+			add("value", t.Value)
+		}
+		if t.Effect != "" {
+			add("effect", t.Effect)
+		}
+		if t.Seconds != nil {
+			add("tolerationSeconds", *t.Seconds)
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
 func LoadEnv(filename string) (Environment, error) {
 	out, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -78,6 +151,36 @@ func LoadSecret(filename string) (*Secret, error) {
 	}, nil
 }
 
+// LoadSecretData reads the values of a plain secret, sops secrets are
+// encrypted and can't be read.
+func LoadSecretData(filename string) (map[string]string, error) {
+	out, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret KubeSecretData
+	if err := yaml.Unmarshal(out, &secret); err != nil {
+		return nil, err
+	}
+	if strings.ToLower(secret.Kind) == "sopssecret" {
+		return nil, ErrEncryptedSecret
+	}
+
+	data := make(map[string]string)
+	for k, v := range secret.Data {
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, k, err)
+		}
+		data[k] = string(decoded)
+	}
+	for k, v := range secret.StringData {
+		data[k] = v
+	}
+	return data, nil
+}
+
 func GetFuncMaps(namespacePrefix string, routePrefix string) template.FuncMap {
 	fm := sprig.TxtFuncMap()
 	fm["toYaml"] = func(v interface{}) string {
@@ -95,6 +198,9 @@ func GetFuncMaps(namespacePrefix string, routePrefix string) template.FuncMap {
 		}
 		return namespacePrefix + ns
 	}
+	fm["redirect"] = ParseRedirect
+	fm["nginxSubroutes"] = nginxSubroutes
+	fm["gatewayRules"] = gatewayRules
 	fm["route"] = func(v interface{}) string {
 		ns, ok := v.(string)
 		if !ok {
@@ -36,3 +36,15 @@ func Test_LoadEnv(t *testing.T) {
 
 	t.Log(env)
 }
+
+func Test_LoadSecretData(t *testing.T) {
+	data, err := LoadSecretData("./example/.secrets/assets.yaml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if data["demo"] != "abc" {
+		t.Errorf("Invalid data: %v", data)
+	}
+}
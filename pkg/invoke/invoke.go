@@ -0,0 +1,110 @@
+package invoke
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Request to send to a function
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Response of a function
+type Response struct {
+	Status   string
+	Code     int
+	Header   http.Header
+	Body     []byte
+	Duration time.Duration
+}
+
+// Do sends the request and reads the whole response
+func Do(ctx context.Context, client *http.Client, r *Request) (*Response, error) {
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range r.Header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	// the host header picks the route, like it does for curl
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Status:   resp.Status,
+		Code:     resp.StatusCode,
+		Header:   resp.Header,
+		Body:     out,
+		Duration: time.Since(start),
+	}, nil
+}
+
+// ParseHeaders in the "Name: value" form curl uses
+func ParseHeaders(headers []string) (http.Header, error) {
+	out := make(http.Header)
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", h)
+		}
+		out.Add(name, strings.TrimSpace(value))
+	}
+	return out, nil
+}
+
+// ReadData is the data itself, or the contents of a file when it starts with
+// @, @- reads stdin
+func ReadData(data string, stdin io.Reader) ([]byte, error) {
+	if !strings.HasPrefix(data, "@") {
+		return []byte(data), nil
+	}
+
+	filename := data[1:]
+	if filename == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(filename)
+}
+
+// Pretty indents json bodies, anything else is left alone
+func Pretty(body []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return body
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
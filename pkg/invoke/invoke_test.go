@@ -0,0 +1,107 @@
+package invoke
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_Do(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"method":"`+r.Method+`","path":"`+r.URL.Path+`","token":"`+r.Header.Get("X-Token")+`","host":"`+r.Host+`","body":`+string(body)+`}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.json")
+	if err := os.WriteFile(filename, []byte(`{"id":1}`), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := ReadData("@"+filename, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	header, err := ParseHeaders([]string{"X-Token: abc", "Host: api.example.com"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := ParseHeaders([]string{"nope"}); err == nil {
+		t.Error("expected an invalid header")
+	}
+
+	resp, err := Do(context.Background(), srv.Client(), &Request{
+		Method: http.MethodPost,
+		URL:    srv.URL + "/api/profile",
+		Header: header,
+		Body:   data,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resp.Code != http.StatusCreated {
+		t.Errorf("expected 201, got %d", resp.Code)
+		return
+	}
+
+	expected := `{
+  "method": "POST",
+  "path": "/api/profile",
+  "token": "abc",
+  "host": "api.example.com",
+  "body": {
+    "id": 1
+  }
+}
+`
+	if out := string(Pretty(resp.Body)); out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+	if out := string(Pretty([]byte("plain"))); out != "plain" {
+		t.Errorf("expected plain to be left alone, got %s", out)
+	}
+
+	stdin, err := ReadData("@-", strings.NewReader("piped"))
+	if err != nil || string(stdin) != "piped" {
+		t.Errorf("expected piped, got %s %v", stdin, err)
+	}
+}
+
+func Test_Load(t *testing.T) {
+	var count int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1)%5 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	stats := Load(context.Background(), srv.Client(), &Request{
+		Method: http.MethodGet,
+		URL:    srv.URL,
+	}, 20, 4)
+
+	if stats.Requests != 20 || stats.Errors != 0 {
+		t.Errorf("expected 20 requests without errors, got %d and %d", stats.Requests, stats.Errors)
+		return
+	}
+	if stats.Codes[200] != 16 || stats.Codes[500] != 4 {
+		t.Errorf("unexpected codes %v", stats.Codes)
+	}
+	if stats.Percentile(50) > stats.Percentile(99) || stats.Mean() <= 0 {
+		t.Errorf("unexpected durations %s %s %s", stats.Percentile(50), stats.Percentile(99), stats.Mean())
+	}
+}
@@ -0,0 +1,102 @@
+package invoke
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats of a load run
+type Stats struct {
+	Requests int
+	Errors   int
+	Codes    map[int]int
+	Total    time.Duration
+
+	durations []time.Duration
+}
+
+// Load sends the request repeat times with concurrency in flight
+func Load(ctx context.Context, client *http.Client, r *Request, repeat int, concurrency int) *Stats {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > repeat {
+		concurrency = repeat
+	}
+
+	stats := &Stats{
+		Codes: make(map[int]int),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan struct{})
+
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				resp, err := Do(ctx, client, r)
+
+				mu.Lock()
+				stats.Requests++
+				if err != nil {
+					stats.Errors++
+				} else {
+					stats.Codes[resp.Code]++
+					stats.durations = append(stats.durations, resp.Duration)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < repeat; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- struct{}{}
+	}
+	close(work)
+	wg.Wait()
+
+	stats.Total = time.Since(start)
+	sort.Slice(stats.durations, func(i, j int) bool {
+		return stats.durations[i] < stats.durations[j]
+	})
+	return stats
+}
+
+// Percentile of the durations of the requests that got a response
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.durations) == 0 {
+		return 0
+	}
+	i := int(float64(len(s.durations)-1) * p / 100)
+	return s.durations[i]
+}
+
+// Mean duration of the requests that got a response
+func (s *Stats) Mean() time.Duration {
+	if len(s.durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range s.durations {
+		sum += d
+	}
+	return sum / time.Duration(len(s.durations))
+}
+
+// Rate is requests per second
+func (s *Stats) Rate() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return float64(s.Requests) / s.Total.Seconds()
+}
@@ -0,0 +1,172 @@
+package kube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+)
+
+// kindOrder is the order resources are applied in, anything else goes last
+var kindOrder = []string{
+	"Namespace",
+	"ServiceAccount",
+	"Secret",
+	"SopsSecret",
+	"ConfigMap",
+	"NetworkPolicy",
+	"Service",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"PodDisruptionBudget",
+	"Certificate",
+	"Policy",
+	"VirtualServer",
+	"VirtualServerRoute",
+	"Gateway",
+	"HTTPRoute",
+	"Ingress",
+}
+
+// Resource is an object applied to the cluster
+type Resource struct {
+	Object   *unstructured.Unstructured
+	Resource schema.GroupVersionResource
+}
+
+// String like kubectl prints it, kind/name
+func (r *Resource) String() string {
+	return fmt.Sprintf("%s/%s", r.Object.GetKind(), r.Object.GetName())
+}
+
+// ApplyOptions for server side apply
+type ApplyOptions struct {
+	// Force takes ownership of fields other managers own
+	Force bool
+	// DryRun has the server check the objects without saving them
+	DryRun bool
+}
+
+// Decode the manifests into objects, a manifest can hold many documents and
+// empty ones are skipped
+func Decode(manifests deployer.Manifests) ([]*unstructured.Unstructured, error) {
+	var out []*unstructured.Unstructured
+	for _, m := range manifests {
+		reader := yaml.NewYAMLReader(bufio.NewReader(strings.NewReader(m.Content)))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Key, err)
+			}
+
+			data, err := yaml.ToJSON(doc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Key, err)
+			}
+			if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+				continue
+			}
+
+			// unstructured keeps numbers as int64 like the api does
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Key, err)
+			}
+			if obj.GetName() == "" {
+				return nil, fmt.Errorf("%s: manifest without a name", m.Key)
+			}
+			out = append(out, obj)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return kindRank(out[i].GetKind()) < kindRank(out[j].GetKind())
+	})
+	return out, nil
+}
+
+// Apply the objects with server side apply, they're applied in order and the
+// first failure stops the rest
+func (c *Client) Apply(ctx context.Context, objs []*unstructured.Unstructured, opts ApplyOptions) ([]*Resource, error) {
+	var out []*Resource
+	for _, obj := range objs {
+		r, err := c.resource(obj)
+		if err != nil {
+			return out, err
+		}
+
+		applyOpts := metav1.ApplyOptions{
+			FieldManager: FieldManager,
+			Force:        opts.Force,
+		}
+		if opts.DryRun {
+			applyOpts.DryRun = []string{metav1.DryRunAll}
+		}
+
+		applied, err := c.dynamic.Resource(r.Resource).Namespace(obj.GetNamespace()).Apply(ctx, obj.GetName(), obj, applyOpts)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", r, err)
+		}
+		if applied != nil {
+			r.Object = applied
+		}
+
+		c.log.Printf("%s applied%s\n", r, dryRunSuffix(opts.DryRun))
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// resource maps an object to its api resource and sets the namespace of
+// namespaced ones that don't have one
+func (c *Client) resource(obj *unstructured.Unstructured) (*Resource, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(c.Namespace)
+		}
+	} else {
+		obj.SetNamespace("")
+	}
+
+	return &Resource{
+		Object:   obj,
+		Resource: mapping.Resource,
+	}, nil
+}
+
+func kindRank(kind string) int {
+	for i, k := range kindOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(kindOrder)
+}
+
+func dryRunSuffix(dryRun bool) string {
+	if dryRun {
+		return " (dry run)"
+	}
+	return ""
+}
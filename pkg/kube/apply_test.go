@@ -0,0 +1,147 @@
+package kube
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// newFakeClient with a tracker that understands server side apply well enough
+// to create and replace objects
+func newFakeClient(objs ...runtime.Object) (*Client, *dynamicfake.FakeDynamicClient) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, meta.RESTScopeNamespace)
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "namespaces"}:                 "NamespaceList",
+		{Version: "v1", Resource: "secrets"}:                    "SecretList",
+		{Version: "v1", Resource: "services"}:                   "ServiceList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+		{Group: "apps", Version: "v1", Resource: "replicasets"}: "ReplicaSetList",
+	}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objs...)
+
+	dyn.PrependReactor("patch", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pa := action.(ktesting.PatchAction)
+		if pa.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(pa.GetPatch()); err != nil {
+			return true, nil, err
+		}
+
+		tracker := dyn.Tracker()
+		_, err := tracker.Get(pa.GetResource(), pa.GetNamespace(), pa.GetName())
+		switch {
+		case apierrors.IsNotFound(err):
+			err = tracker.Create(pa.GetResource(), obj, pa.GetNamespace())
+		case err == nil:
+			err = tracker.Update(pa.GetResource(), obj, pa.GetNamespace())
+		}
+		return true, obj, err
+	})
+
+	return NewClientFrom(print.NewLog(io.Discard), dyn, mapper, "dev"), dyn
+}
+
+func Test_Apply(t *testing.T) {
+	manifests := deployer.Manifests{
+		{
+			Type: deployer.DeploymentManifestType,
+			Key:  "api",
+			Content: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: 2
+`,
+		},
+		{
+			Type:    deployer.SecretManifestType,
+			Key:     "api-secret",
+			Content: "---\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: api-secret\n  namespace: other\n",
+		},
+		{
+			Key:     "namespace",
+			Content: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: dev\n  namespace: ignored\n",
+		},
+	}
+
+	objs, err := Decode(manifests)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	c, dyn := newFakeClient()
+	applied, err := c.Apply(context.Background(), objs, ApplyOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []string{"Namespace/dev", "Secret/api-secret", "Deployment/api"}
+	if len(applied) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, applied)
+		return
+	}
+	for i, r := range applied {
+		if r.String() != expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, r)
+		}
+	}
+
+	namespaces := map[string]string{
+		"Namespace/dev":     "",
+		"Secret/api-secret": "other",
+		"Deployment/api":    "dev",
+	}
+	for _, r := range applied {
+		if ns := r.Object.GetNamespace(); ns != namespaces[r.String()] {
+			t.Errorf("%s: expected namespace %q, got %q", r, namespaces[r.String()], ns)
+		}
+	}
+
+	got, err := dyn.Tracker().Get(deploymentResource, "dev", "api")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	replicas, _, _ := unstructured.NestedInt64(got.(*unstructured.Unstructured).Object, "spec", "replicas")
+	if replicas != 2 {
+		t.Errorf("expected 2 replicas, got %d", replicas)
+	}
+
+	// applying again replaces
+	if _, err := c.Apply(context.Background(), objs, ApplyOptions{}); err != nil {
+		t.Error(err)
+	}
+
+	// kinds the cluster doesn't know fail
+	if _, err := c.Apply(context.Background(), []*unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "k8s.nginx.org/v1",
+		"kind":       "VirtualServer",
+		"metadata":   map[string]interface{}{"name": "api"},
+	}}}, ApplyOptions{}); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,68 @@
+package kube
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// FieldManager owns the fields ccb applies
+const FieldManager = "ccb"
+
+// Client applies manifests to a cluster
+type Client struct {
+	log     print.Log
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+
+	// Namespace for resources that don't set one
+	Namespace string
+}
+
+// NewClient from a kubeconfig, the default loading rules are used when it's
+// empty. The namespace defaults to the one of the context.
+func NewClient(log print.Log, kubeconfig string, context string, namespace string) (*Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: context,
+	})
+
+	rest, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		if namespace, _, err = config.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	dyn, err := dynamic.NewForConfig(rest)
+	if err != nil {
+		return nil, err
+	}
+	disc, err := discovery.NewDiscoveryClientForConfig(rest)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disc))
+
+	return NewClientFrom(log, dyn, mapper, namespace), nil
+}
+
+// NewClientFrom existing clients, used by tests with fakes
+func NewClientFrom(log print.Log, dyn dynamic.Interface, mapper meta.RESTMapper, namespace string) *Client {
+	return &Client{
+		log:       log,
+		dynamic:   dyn,
+		mapper:    mapper,
+		Namespace: namespace,
+	}
+}
@@ -0,0 +1,355 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+)
+
+// ChangeType of a resource between two sets of manifests
+type ChangeType string
+
+var (
+	Added   ChangeType = "added"
+	Changed ChangeType = "changed"
+	Removed ChangeType = "removed"
+)
+
+// Change to a resource
+type Change struct {
+	Type      ChangeType
+	Kind      string
+	Namespace string
+	Name      string
+	Fields    []FieldChange
+}
+
+func (c *Change) String() string {
+	return fmt.Sprintf("%s/%s", c.Kind, c.Name)
+}
+
+// FieldChange is a field that differs, Old or New is nil when it's missing
+// from that side
+type FieldChange struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+// serverFields are filled in by the api server, they're not part of what was
+// rendered so they're never a change
+var serverFields = [][]string{
+	{"status"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "managedFields"},
+	{"metadata", "selfLink"},
+	{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	{"metadata", "annotations", "deployment.kubernetes.io/revision"},
+}
+
+// Diff two sets of objects, resources are matched by kind, namespace and name
+func Diff(old []*unstructured.Unstructured, new []*unstructured.Unstructured) []*Change {
+	before := make(map[string]*unstructured.Unstructured)
+	for _, obj := range old {
+		before[objectID(obj)] = obj
+	}
+
+	var out []*Change
+	seen := make(map[string]bool)
+	for _, obj := range new {
+		id := objectID(obj)
+		seen[id] = true
+
+		prev, ok := before[id]
+		if !ok {
+			out = append(out, newChange(Added, obj, nil))
+			continue
+		}
+		if fields := Compare(prev, obj); len(fields) > 0 {
+			out = append(out, newChange(Changed, obj, fields))
+		}
+	}
+	for _, obj := range old {
+		if !seen[objectID(obj)] {
+			out = append(out, newChange(Removed, obj, nil))
+		}
+	}
+
+	sortChanges(out)
+	return out
+}
+
+// Compare two versions of an object without the fields the server fills in.
+// Maps ignore key order and lists of named items, like containers and env,
+// are matched by name.
+func Compare(old *unstructured.Unstructured, new *unstructured.Unstructured) []FieldChange {
+	var out []FieldChange
+	compareValue("", normalize(old), normalize(new), &out)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// DiffLive compares objects with the cluster. Changes are what a server side
+// apply would do, found with a dry run so defaults the server adds don't show
+// up. Resources of the stack that aren't in objs are removed.
+func (c *Client) DiffLive(ctx context.Context, stack string, objs []*unstructured.Unstructured) ([]*Change, error) {
+	var out []*Change
+	var keep []*Resource
+	for _, obj := range objs {
+		r, err := c.resource(obj.DeepCopy())
+		if err != nil {
+			return nil, err
+		}
+		keep = append(keep, r)
+
+		client := c.dynamic.Resource(r.Resource).Namespace(r.Object.GetNamespace())
+		live, err := client.Get(ctx, r.Object.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			out = append(out, newChange(Added, r.Object, nil))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r, err)
+		}
+
+		applied, err := client.Apply(ctx, r.Object.GetName(), r.Object, metav1.ApplyOptions{
+			FieldManager: FieldManager,
+			Force:        true,
+			DryRun:       []string{metav1.DryRunAll},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r, err)
+		}
+		if fields := Compare(live, applied); len(fields) > 0 {
+			out = append(out, newChange(Changed, r.Object, fields))
+		}
+	}
+
+	if stack != "" {
+		leftovers, err := c.leftovers(ctx, stack, keep)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range leftovers {
+			out = append(out, newChange(Removed, r.Object, nil))
+		}
+	}
+
+	sortChanges(out)
+	return out, nil
+}
+
+// LoadObjects from a manifest file or every yaml file in a directory
+func LoadObjects(p string) ([]*unstructured.Unstructured, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{p}
+	if info.IsDir() {
+		files = nil
+		err := filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(path); !d.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".json") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var manifests deployer.Manifests
+	for _, filename := range files {
+		out, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, deployer.Manifest{
+			Key:     filename,
+			Content: string(out),
+		})
+	}
+	return Decode(manifests)
+}
+
+// WriteChanges like a diff, + added, - removed and ~ changed with the fields
+func WriteChanges(w io.Writer, changes []*Change) {
+	for _, c := range changes {
+		switch c.Type {
+		case Added:
+			fmt.Fprintf(w, "+ %s\n", c)
+		case Removed:
+			fmt.Fprintf(w, "- %s\n", c)
+		case Changed:
+			fmt.Fprintf(w, "~ %s\n", c)
+			for _, f := range c.Fields {
+				switch {
+				case f.Old == nil:
+					fmt.Fprintf(w, "    + %s: %s\n", f.Path, formatValue(f.New))
+				case f.New == nil:
+					fmt.Fprintf(w, "    - %s: %s\n", f.Path, formatValue(f.Old))
+				default:
+					fmt.Fprintf(w, "    ~ %s: %s -> %s\n", f.Path, formatValue(f.Old), formatValue(f.New))
+				}
+			}
+		}
+	}
+}
+
+func newChange(t ChangeType, obj *unstructured.Unstructured, fields []FieldChange) *Change {
+	return &Change{
+		Type:      t,
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Fields:    fields,
+	}
+}
+
+func sortChanges(changes []*Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		ri, rj := kindRank(changes[i].Kind), kindRank(changes[j].Kind)
+		if ri != rj {
+			return ri < rj
+		}
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+}
+
+func objectID(obj *unstructured.Unstructured) string {
+	return strings.Join([]string{obj.GroupVersionKind().Group, obj.GetKind(), obj.GetNamespace(), obj.GetName()}, "/")
+}
+
+func normalize(obj *unstructured.Unstructured) map[string]interface{} {
+	out := obj.DeepCopy().Object
+	for _, fields := range serverFields {
+		unstructured.RemoveNestedField(out, fields...)
+	}
+	if annotations, ok, _ := unstructured.NestedMap(out, "metadata", "annotations"); ok && len(annotations) == 0 {
+		unstructured.RemoveNestedField(out, "metadata", "annotations")
+	}
+	return out
+}
+
+func compareValue(path string, old interface{}, new interface{}, out *[]FieldChange) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			compareMap(path, o, n, out)
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			compareList(path, o, n, out)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*out = append(*out, FieldChange{Path: path, Old: old, New: new})
+	}
+}
+
+func compareMap(path string, old map[string]interface{}, new map[string]interface{}, out *[]FieldChange) {
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		compareValue(p, old[k], new[k], out)
+	}
+}
+
+func compareList(path string, old []interface{}, new []interface{}, out *[]FieldChange) {
+	oldNames, okOld := itemNames(old)
+	newNames, okNew := itemNames(new)
+
+	// named items are matched by name so reordering isn't a change
+	if (okOld || len(old) == 0) && (okNew || len(new) == 0) && (okOld || okNew) {
+		for name, o := range oldNames {
+			compareValue(fmt.Sprintf("%s[%s]", path, name), o, newNames[name], out)
+		}
+		for name, n := range newNames {
+			if _, ok := oldNames[name]; !ok {
+				compareValue(fmt.Sprintf("%s[%s]", path, name), nil, n, out)
+			}
+		}
+		return
+	}
+
+	for i := 0; i < len(old) || i < len(new); i++ {
+		var o, n interface{}
+		if i < len(old) {
+			o = old[i]
+		}
+		if i < len(new) {
+			n = new[i]
+		}
+		compareValue(fmt.Sprintf("%s[%d]", path, i), o, n, out)
+	}
+}
+
+// itemNames indexes a list by the name of its items, when they all have one
+func itemNames(items []interface{}) (map[string]interface{}, bool) {
+	if len(items) == 0 {
+		return nil, false
+	}
+
+	out := make(map[string]interface{})
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok || name == "" {
+			return nil, false
+		}
+		if _, dup := out[name]; dup {
+			return nil, false
+		}
+		out[name] = item
+	}
+	return out, true
+}
+
+func formatValue(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(out)
+}
@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+)
+
+const diffDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: dev
+  labels:
+    ccb.contextcloud.dev/stack: shop
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: api
+        image: api:1
+        env:
+        - name: A
+          value: "1"
+        - name: B
+          value: "2"
+      - name: proxy
+        image: proxy:1
+`
+
+func Test_Diff(t *testing.T) {
+	dir := t.TempDir()
+	previous := diffDeployment + `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: old
+  namespace: dev
+`
+	if err := os.WriteFile(filepath.Join(dir, "previous.yaml"), []byte(previous), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	old, err := LoadObjects(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// reordered containers and env aren't changes, the image and replicas are
+	current := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  namespace: dev
+  name: api
+  labels:
+    ccb.contextcloud.dev/stack: shop
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: proxy
+        image: proxy:1
+      - name: api
+        image: api:2
+        env:
+        - name: B
+          value: "2"
+        - name: A
+          value: "1"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: dev
+`
+	new, err := Decode(deployer.Manifests{{Key: "current", Content: current}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	changes := Diff(old, new)
+
+	var buf bytes.Buffer
+	WriteChanges(&buf, changes)
+
+	expected := `+ Service/api
+- Service/old
+~ Deployment/api
+    ~ spec.replicas: 2 -> 3
+    ~ spec.template.spec.containers[api].image: "api:1" -> "api:2"
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func Test_DiffLive(t *testing.T) {
+	live, err := Decode(deployer.Manifests{{Key: "live", Content: diffDeployment}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// what the server adds isn't a change
+	obj := live[0]
+	_ = unstructured.SetNestedField(obj.Object, "123", "metadata", "resourceVersion")
+	_ = unstructured.SetNestedField(obj.Object, int64(2), "status", "replicas")
+
+	leftover := &unstructured.Unstructured{}
+	leftover.SetAPIVersion("v1")
+	leftover.SetKind("Service")
+	leftover.SetName("old")
+	leftover.SetNamespace("dev")
+	leftover.SetLabels(map[string]string{deployer.LabelStack: "shop"})
+
+	c, _ := newFakeClient(obj, leftover)
+
+	desired, err := Decode(deployer.Manifests{{Key: "desired", Content: diffDeployment}, {
+		Key:     "service",
+		Content: "apiVersion: v1\nkind: Service\nmetadata:\n  name: api\n",
+	}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, obj := range desired {
+		if obj.GetKind() == "Deployment" {
+			_ = unstructured.SetNestedField(obj.Object, int64(4), "spec", "replicas")
+		}
+	}
+
+	changes, err := c.DiffLive(context.Background(), "shop", desired)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	WriteChanges(&buf, changes)
+
+	expected := `+ Service/api
+- Service/old
+~ Deployment/api
+    ~ spec.replicas: 2 -> 4
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
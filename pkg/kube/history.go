@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// ErrNoRevision when the revision to roll back to isn't in the history
+	ErrNoRevision = errors.New("revision not found")
+	// ErrCurrentRevision when rolling back to the revision that's running
+	ErrCurrentRevision = errors.New("already at revision")
+)
+
+var replicaSetResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}
+
+const (
+	revisionAnnotation = "deployment.kubernetes.io/revision"
+	podTemplateHash    = "pod-template-hash"
+	commitLabel        = "commit"
+)
+
+// Revision of a deployment, each is a ReplicaSet the deployment kept
+type Revision struct {
+	Number  int64
+	Commit  string
+	Images  []string
+	Created time.Time
+	Ready   int32
+	Current bool
+
+	template map[string]interface{}
+}
+
+// History of a deployment, oldest revision first
+func (c *Client) History(ctx context.Context, name string) ([]*Revision, error) {
+	d, _, err := c.deployment(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.revisions(ctx, d)
+}
+
+// Rollback a deployment to a revision by applying its pod template again, zero
+// is the revision before the current one
+func (c *Client) Rollback(ctx context.Context, name string, to int64) (*Revision, error) {
+	d, live, err := c.deployment(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := c.revisions(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var current, target *Revision
+	for _, r := range revisions {
+		if r.Current {
+			current = r
+		}
+	}
+	for _, r := range revisions {
+		switch {
+		case to > 0 && r.Number == to:
+			target = r
+		case to == 0 && !r.Current && (current == nil || r.Number < current.Number):
+			// the newest revision before the current one
+			target = r
+		}
+	}
+	if target == nil {
+		if to == 0 {
+			return nil, fmt.Errorf("%w: %s has no previous revision", ErrNoRevision, name)
+		}
+		return nil, fmt.Errorf("%w: %s has no revision %d", ErrNoRevision, name, to)
+	}
+	if target.Current {
+		return nil, fmt.Errorf("%w %d", ErrCurrentRevision, target.Number)
+	}
+
+	// apply everything ccb owns so nothing is dropped, with the old template
+	obj := &unstructured.Unstructured{Object: normalize(live)}
+	if err := unstructured.SetNestedField(obj.Object, target.template, "spec", "template"); err != nil {
+		return nil, err
+	}
+
+	_, err = c.dynamic.Resource(deploymentResource).Namespace(d.Namespace).Apply(ctx, name, obj, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	c.log.Printf("%s: rolled back to revision %d\n", name, target.Number)
+	return target, nil
+}
+
+func (c *Client) deployment(ctx context.Context, name string) (*appsv1.Deployment, *unstructured.Unstructured, error) {
+	obj, err := c.dynamic.Resource(deploymentResource).Namespace(c.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var d appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &d); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &d, obj, nil
+}
+
+// revisions are the ReplicaSets the deployment owns
+func (c *Client) revisions(ctx context.Context, d *appsv1.Deployment) ([]*Revision, error) {
+	var selector string
+	if d.Spec.Selector != nil {
+		selector = labels.SelectorFromSet(d.Spec.Selector.MatchLabels).String()
+	}
+
+	list, err := c.dynamic.Resource(replicaSetResource).Namespace(d.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.Name, err)
+	}
+
+	current := d.Annotations[revisionAnnotation]
+
+	var out []*Revision
+	for i := range list.Items {
+		item := &list.Items[i]
+
+		var rs appsv1.ReplicaSet
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &rs); err != nil {
+			return nil, err
+		}
+		if owner := metav1.GetControllerOf(&rs); owner == nil || owner.UID != d.UID {
+			continue
+		}
+
+		number, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		template, _, err := unstructured.NestedMap(item.Object, "spec", "template")
+		if err != nil {
+			return nil, err
+		}
+		unstructured.RemoveNestedField(template, "metadata", "labels", podTemplateHash)
+		unstructured.RemoveNestedField(template, "metadata", "creationTimestamp")
+
+		r := &Revision{
+			Number:   number,
+			Commit:   rs.Spec.Template.Labels[commitLabel],
+			Created:  rs.CreationTimestamp.Time,
+			Ready:    rs.Status.ReadyReplicas,
+			Current:  rs.Annotations[revisionAnnotation] == current,
+			template: template,
+		}
+		for _, container := range rs.Spec.Template.Spec.Containers {
+			r.Images = append(r.Images, container.Image)
+		}
+		out = append(out, r)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Number < out[j].Number
+	})
+	return out, nil
+}
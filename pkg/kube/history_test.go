@@ -0,0 +1,135 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_Rollback(t *testing.T) {
+	selector := map[string]string{"release": "api"}
+	template := func(revision int) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"release":       "api",
+					"commit":        fmt.Sprintf("c%d", revision),
+					podTemplateHash: fmt.Sprintf("hash%d", revision),
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "api", Image: fmt.Sprintf("api:%d", revision)}},
+			},
+		}
+	}
+	toObject := func(obj interface{}) *unstructured.Unstructured {
+		raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &unstructured.Unstructured{Object: raw}
+	}
+
+	current := template(3)
+	delete(current.Labels, podTemplateHash)
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "api",
+			Namespace:   "dev",
+			UID:         "d1",
+			Annotations: map[string]string{revisionAnnotation: "3"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: current,
+		},
+	}
+
+	objs := []runtime.Object{toObject(deployment)}
+	replicaSet := func(name string, revision int, owner types.UID) {
+		controller := true
+		objs = append(objs, toObject(&appsv1.ReplicaSet{
+			TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "dev",
+				Labels:      selector,
+				Annotations: map[string]string{revisionAnnotation: fmt.Sprint(revision)},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "Deployment", Name: "api", UID: owner, Controller: &controller,
+				}},
+			},
+			Spec: appsv1.ReplicaSetSpec{Template: template(revision)},
+		}))
+	}
+	replicaSet("api-1", 1, "d1")
+	replicaSet("api-3", 3, "d1")
+	replicaSet("api-2", 2, "d1")
+	replicaSet("stale", 4, "someone-else")
+
+	c, dyn := newFakeClient(objs...)
+
+	history, err := c.History(context.Background(), "api")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 3 {
+		t.Errorf("expected 3 revisions, got %d", len(history))
+		return
+	}
+	for i, r := range history {
+		if r.Number != int64(i+1) || r.Commit != fmt.Sprintf("c%d", i+1) || r.Images[0] != fmt.Sprintf("api:%d", i+1) {
+			t.Errorf("unexpected revision %+v", r)
+		}
+		if r.Current != (r.Number == 3) {
+			t.Errorf("revision %d current %v", r.Number, r.Current)
+		}
+	}
+
+	if _, err := c.Rollback(context.Background(), "api", 3); !errors.Is(err, ErrCurrentRevision) {
+		t.Errorf("expected ErrCurrentRevision, got %v", err)
+	}
+	if _, err := c.Rollback(context.Background(), "api", 9); !errors.Is(err, ErrNoRevision) {
+		t.Errorf("expected ErrNoRevision, got %v", err)
+	}
+
+	target, err := c.Rollback(context.Background(), "api", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if target.Number != 2 {
+		t.Errorf("expected to roll back to 2, got %d", target.Number)
+		return
+	}
+
+	obj, err := dyn.Tracker().Get(deploymentResource, "dev", "api")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var d appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).Object, &d); err != nil {
+		t.Error(err)
+		return
+	}
+	if image := d.Spec.Template.Spec.Containers[0].Image; image != "api:2" {
+		t.Errorf("expected api:2, got %s", image)
+	}
+	if _, ok := d.Spec.Template.Labels[podTemplateHash]; ok {
+		t.Error("the pod template hash should be dropped")
+	}
+	if d.Spec.Selector == nil || d.Spec.Selector.MatchLabels["release"] != "api" {
+		t.Error("the selector should be kept")
+	}
+}
@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+)
+
+// pruneKinds are the kinds ccb renders, nothing else is ever pruned. Secrets
+// are copied from the stack as they are so they don't carry the labels.
+var pruneKinds = []schema.GroupVersionKind{
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Version: "v1", Kind: "Service"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "NetworkPolicy"},
+	{Group: "autoscaling", Version: "v2", Kind: "HorizontalPodAutoscaler"},
+	{Group: "policy", Version: "v1", Kind: "PodDisruptionBudget"},
+	{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"},
+	{Group: "k8s.nginx.org", Version: "v1", Kind: "Policy"},
+	{Group: "k8s.nginx.org", Version: "v1", Kind: "VirtualServer"},
+	{Group: "k8s.nginx.org", Version: "v1", Kind: "VirtualServerRoute"},
+	{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"},
+	{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+}
+
+// Prune deletes the resources of the stack in the namespace that aren't in
+// keep, they're all listed before any is deleted and with dryRun they're only
+// listed
+func (c *Client) Prune(ctx context.Context, stack string, keep []*Resource, dryRun bool) ([]*Resource, error) {
+	out, err := c.leftovers(ctx, stack, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range out {
+		if dryRun {
+			c.log.Printf("%s pruned (dry run)\n", r)
+		} else {
+			c.log.Printf("%s will be pruned\n", r)
+		}
+	}
+	if dryRun {
+		return out, nil
+	}
+
+	for _, r := range out {
+		policy := metav1.DeletePropagationBackground
+		err := c.dynamic.Resource(r.Resource).Namespace(r.Object.GetNamespace()).Delete(ctx, r.Object.GetName(), metav1.DeleteOptions{
+			PropagationPolicy: &policy,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return out, fmt.Errorf("%s: %w", r, err)
+		}
+		c.log.Printf("%s pruned\n", r)
+	}
+	return out, nil
+}
+
+// leftovers are the resources labelled with the stack that aren't in keep,
+// in the order to remove them
+func (c *Client) leftovers(ctx context.Context, stack string, keep []*Resource) ([]*Resource, error) {
+	kept := make(map[string]bool)
+	for _, r := range keep {
+		kept[resourceID(r.Resource.GroupResource(), r.Object.GetNamespace(), r.Object.GetName())] = true
+	}
+
+	selector := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", deployer.LabelStack, deployer.LabelValue(stack)),
+	}
+
+	var out []*Resource
+	for _, gvk := range pruneKinds {
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			// the cluster doesn't have it, so there's nothing to prune
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := c.dynamic.Resource(mapping.Resource).Namespace(c.Namespace).List(ctx, selector)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", gvk.Kind, err)
+		}
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if kept[resourceID(mapping.Resource.GroupResource(), obj.GetNamespace(), obj.GetName())] {
+				continue
+			}
+			obj.SetGroupVersionKind(gvk)
+			out = append(out, &Resource{
+				Object:   obj,
+				Resource: mapping.Resource,
+			})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		// remove in the reverse order of applying
+		ri, rj := kindRank(out[i].Object.GetKind()), kindRank(out[j].Object.GetKind())
+		if ri != rj {
+			return ri > rj
+		}
+		return out[i].Object.GetName() < out[j].Object.GetName()
+	})
+	return out, nil
+}
+
+func resourceID(gr schema.GroupResource, namespace string, name string) string {
+	return gr.String() + "/" + namespace + "/" + name
+}
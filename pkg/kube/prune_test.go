@@ -0,0 +1,96 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+func Test_Prune(t *testing.T) {
+	object := func(apiVersion string, kind string, name string, stack string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetName(name)
+		obj.SetNamespace("dev")
+		obj.SetLabels(map[string]string{deployer.LabelStack: stack})
+		return obj
+	}
+
+	api := object("apps/v1", "Deployment", "api", "shop")
+	c, dyn := newFakeClient(
+		api,
+		object("apps/v1", "Deployment", "old", "shop"),
+		object("apps/v1", "Deployment", "other", "blog"),
+		object("v1", "Service", "old", "shop"),
+		object("v1", "Service", "api", "shop"),
+	)
+
+	keep := []*Resource{
+		{Object: api, Resource: deploymentResource},
+		{Object: object("v1", "Service", "api", "shop"), Resource: schema.GroupVersionResource{Version: "v1", Resource: "services"}},
+	}
+
+	exists := func(gvr schema.GroupVersionResource, name string) bool {
+		_, err := dyn.Tracker().Get(gvr, "dev", name)
+		return !apierrors.IsNotFound(err)
+	}
+	services := schema.GroupVersionResource{Version: "v1", Resource: "services"}
+
+	listed, err := c.Prune(context.Background(), "shop", keep, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []string{"Deployment/old", "Service/old"}
+	if len(listed) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, listed)
+		return
+	}
+	for i, r := range listed {
+		if r.String() != expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, r)
+		}
+	}
+	if !exists(deploymentResource, "old") || !exists(services, "old") {
+		t.Error("dry run deleted resources")
+		return
+	}
+
+	// everything is listed before anything is deleted
+	var buf bytes.Buffer
+	var before string
+	c.log = print.NewLog(&buf)
+	dyn.PrependReactor("delete", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if before == "" {
+			before = buf.String()
+		}
+		return false, nil, nil
+	})
+
+	if _, err := c.Prune(context.Background(), "shop", keep, false); err != nil {
+		t.Error(err)
+		return
+	}
+	if exists(deploymentResource, "old") || exists(services, "old") {
+		t.Error("expected old to be pruned")
+	}
+	for _, name := range []string{"api", "other"} {
+		if !exists(deploymentResource, name) {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+	if before != "Deployment/old will be pruned\nService/old will be pruned\n" {
+		t.Errorf("expected the leftovers to be listed first, got %q", before)
+	}
+}
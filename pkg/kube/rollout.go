@@ -0,0 +1,118 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrRolloutFailed when a deployment passes its progress deadline
+var ErrRolloutFailed = errors.New("rollout failed")
+
+var deploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+// pollInterval between checks of a rollout
+var pollInterval = 2 * time.Second
+
+// RolloutStatus is where a deployment's rollout is at, the same checks
+// kubectl rollout status does
+func RolloutStatus(d *appsv1.Deployment) (string, bool, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return "waiting for the spec update to be observed", false, nil
+	}
+
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			return "", false, fmt.Errorf("%w: %s", ErrRolloutFailed, c.Message)
+		}
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("%d of %d new replicas updated", d.Status.UpdatedReplicas, replicas), false, nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), false, nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), false, nil
+	}
+	return "rolled out", true, nil
+}
+
+// WaitRollout of a deployment, the status is printed when it changes
+func (c *Client) WaitRollout(ctx context.Context, namespace string, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var last string
+	for {
+		obj, err := c.dynamic.Resource(deploymentResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		var d appsv1.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &d); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		status, done, err := RolloutStatus(&d)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if status != last {
+			c.log.Printf("%s: %s\n", name, status)
+			last = status
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: timed out after %s, %s", name, timeout, last)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitRollouts of every deployment in the resources at the same time
+func (c *Client) WaitRollouts(ctx context.Context, resources []*Resource, timeout time.Duration) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for _, r := range resources {
+		if r.Resource != deploymentResource {
+			continue
+		}
+
+		wg.Add(1)
+		go func(namespace string, name string) {
+			defer wg.Done()
+			if err := c.WaitRollout(ctx, namespace, name, timeout); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(r.Object.GetNamespace(), r.Object.GetName())
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
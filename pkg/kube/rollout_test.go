@@ -0,0 +1,122 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_RolloutStatus(t *testing.T) {
+	two := int32(2)
+	cases := []struct {
+		name   string
+		d      appsv1.Deployment
+		done   bool
+		failed bool
+	}{
+		{
+			name: "not observed",
+			d: appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+			},
+		},
+		{
+			name: "updating",
+			d: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &two},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 1, Replicas: 2},
+			},
+		},
+		{
+			name: "old replicas",
+			d: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &two},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 2, Replicas: 3},
+			},
+		},
+		{
+			name: "unavailable",
+			d: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &two},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 2, Replicas: 2, AvailableReplicas: 1},
+			},
+		},
+		{
+			name: "deadline",
+			d: appsv1.Deployment{
+				Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{{
+					Type:   appsv1.DeploymentProgressing,
+					Status: corev1.ConditionFalse,
+					Reason: "ProgressDeadlineExceeded",
+				}}},
+			},
+			failed: true,
+		},
+		{
+			name: "done",
+			d: appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &two},
+				Status: appsv1.DeploymentStatus{UpdatedReplicas: 2, Replicas: 2, AvailableReplicas: 2},
+			},
+			done: true,
+		},
+	}
+
+	for _, c := range cases {
+		_, done, err := RolloutStatus(&c.d)
+		if c.failed != errors.Is(err, ErrRolloutFailed) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if done != c.done {
+			t.Errorf("%s: expected done %v, got %v", c.name, c.done, done)
+		}
+	}
+}
+
+func Test_WaitRollouts(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+
+	replicas := int32(1)
+	d := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+	}
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	obj := &unstructured.Unstructured{Object: raw}
+
+	c, dyn := newFakeClient(obj)
+	resources := []*Resource{{Object: obj, Resource: deploymentResource}}
+
+	if err := c.WaitRollouts(context.Background(), resources, 50*time.Millisecond); err == nil {
+		t.Error("expected a timeout")
+		return
+	}
+
+	// the replica becomes available
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ready := obj.DeepCopy()
+		_ = unstructured.SetNestedField(ready.Object, int64(1), "status", "updatedReplicas")
+		_ = unstructured.SetNestedField(ready.Object, int64(1), "status", "replicas")
+		_ = unstructured.SetNestedField(ready.Object, int64(1), "status", "availableReplicas")
+		_ = dyn.Tracker().Update(deploymentResource, ready, "dev")
+	}()
+
+	if err := c.WaitRollouts(context.Background(), resources, time.Second); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,18 +6,121 @@ type FunctionResources struct {
 	CPU    string `yaml:"cpu,omitempty"`
 }
 
+// Operators a route header is matched with, eq when it's not set
+const (
+	HeaderEquals    = "eq"
+	HeaderNotEquals = "ne"
+	HeaderRegex     = "regex"
+)
+
 // RouterHeader is used to set the header for the router
 type RouteHeader struct {
 	Name     string `yaml:"name"`
-	Operator string `yaml:"op"`
+	Operator string `yaml:"op,omitempty"`
 	Value    string `yaml:"value"`
 }
 
+// RouteCORS are the CORS headers added to the responses of a route
+type RouteCORS struct {
+	Origin      string   `yaml:"origin"`
+	Methods     []string `yaml:"methods,omitempty"`
+	Headers     []string `yaml:"headers,omitempty"`
+	Credentials bool     `yaml:"credentials,omitempty"`
+	MaxAge      int      `yaml:"max_age,omitempty"`
+}
+
+// RouteRateLimit is the requests per second a client can send to a route
+type RouteRateLimit struct {
+	Rate  int `yaml:"rate"`
+	Burst int `yaml:"burst,omitempty"`
+}
+
 // Stack is a stack of functions
 type Stack struct {
-	Provider  Provider            `yaml:"provider,omitempty"`
-	Functions map[string]Function `yaml:"functions,omitempty"`
-	Routes    map[string]Route    `yaml:"routes,omitempty"`
+	Name      string                    `yaml:"name,omitempty"`
+	Provider  Provider                  `yaml:"provider,omitempty"`
+	Templates map[string]TemplateSource `yaml:"templates,omitempty"`
+	Functions map[string]Function       `yaml:"functions,omitempty"`
+	Routes    map[string]Route          `yaml:"routes,omitempty"`
+	Releases  map[string]Release        `yaml:"releases,omitempty"`
+	Router    Router                    `yaml:"router,omitempty"`
+	Network   Network                   `yaml:"network,omitempty"`
+	// Scheduling defaults of the functions
+	Scheduling Scheduling `yaml:"scheduling,omitempty"`
+}
+
+// TemplateSource is a go-getter source for templates, keyed by template name or glob.
+// It's either the source itself or the full form with credentials for private git.
+type TemplateSource struct {
+	Source   string `yaml:"source"`
+	SSHKey   string `yaml:"ssh_key,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain source string as well as the full form
+func (s *TemplateSource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var src string
+	if err := unmarshal(&src); err == nil {
+		s.Source = src
+		return nil
+	}
+
+	type plain TemplateSource
+	return unmarshal((*plain)(s))
+}
+
+// Routers the routes can be rendered for
+const (
+	RouterNginx      = "nginx"
+	RouterGatewayAPI = "gateway-api"
+	RouterIngress    = "ingress"
+)
+
+// Router is what serves the routes in the cluster and how their certificates
+// are issued. Gateway is the namespace/name the HTTPRoutes of functions attach
+// to and Class the gateway or ingress class.
+type Router struct {
+	Kind       string `yaml:"kind,omitempty"`
+	Issuer     string `yaml:"issuer,omitempty"`
+	IssuerKind string `yaml:"issuer_kind,omitempty"`
+	Gateway    string `yaml:"gateway,omitempty"`
+	Class      string `yaml:"class,omitempty"`
+}
+
+// Network restricts the traffic between functions with NetworkPolicies when
+// Policies is set, functions only accept what they're called by, the router
+// and the metrics scraper
+type Network struct {
+	Policies         bool   `yaml:"policies,omitempty"`
+	IngressNamespace string `yaml:"ingress_namespace,omitempty"`
+	MetricsNamespace string `yaml:"metrics_namespace,omitempty"`
+}
+
+// How the pods of a function are spread across the nodes, none turns it off
+const (
+	SpreadNone           = "none"
+	SpreadDoNotSchedule  = "DoNotSchedule"
+	SpreadScheduleAnyway = "ScheduleAnyway"
+)
+
+// Toleration of a node taint
+type Toleration struct {
+	Key      string `yaml:"key,omitempty"`
+	Operator string `yaml:"operator,omitempty"`
+	Value    string `yaml:"value,omitempty"`
+	Effect   string `yaml:"effect,omitempty"`
+	Seconds  *int64 `yaml:"seconds,omitempty"`
+}
+
+// Scheduling of the pods of a function, Affinity is a pod affinity as
+// kubernetes takes it. Unset they go on GKE spot nodes one per node.
+type Scheduling struct {
+	NodeSelector  map[string]string      `yaml:"node_selector,omitempty"`
+	Tolerations   []Toleration           `yaml:"tolerations,omitempty"`
+	Affinity      map[string]interface{} `yaml:"affinity,omitempty"`
+	Spread        string                 `yaml:"spread,omitempty"`
+	PriorityClass string                 `yaml:"priority_class,omitempty"`
 }
 
 // Provider for the FaaS set of functions.
@@ -25,12 +128,19 @@ type Provider struct {
 	Version string `yaml:"version,omitempty"`
 }
 
-// FunctionRoute is a route to a function
+// FunctionRoute is a route to a function. Rewrite replaces the prefix before
+// the request reaches the function, "/" strips it, and Timeout is a duration.
 type FunctionRoute struct {
-	Name     string `yaml:"name,omitempty"`
-	FQDN     string `yaml:"fqdn,omitempty"`
-	Prefix   string `yaml:"prefix,omitempty"`
-	Redirect string `yaml:"redirect,omitempty"`
+	Name      string          `yaml:"name,omitempty"`
+	FQDN      string          `yaml:"fqdn,omitempty"`
+	Prefix    string          `yaml:"prefix,omitempty"`
+	Redirect  string          `yaml:"redirect,omitempty"`
+	Headers   []RouteHeader   `yaml:"headers,omitempty"`
+	Methods   []string        `yaml:"methods,omitempty"`
+	Rewrite   string          `yaml:"rewrite,omitempty"`
+	Timeout   string          `yaml:"timeout,omitempty"`
+	CORS      *RouteCORS      `yaml:"cors,omitempty"`
+	RateLimit *RouteRateLimit `yaml:"rate_limit,omitempty"`
 }
 
 // Function as deployed or built
@@ -53,6 +163,8 @@ type Function struct {
 	Limits         *FunctionResources `yaml:"limits"`
 	Requests       *FunctionResources `yaml:"requests"`
 	Routes         []FunctionRoute    `yaml:"routes,omitempty"`
+	Calls          []string           `yaml:"calls,omitempty"`
+	Scheduling     *Scheduling        `yaml:"scheduling,omitempty"`
 }
 
 // RouteInclude is a route to a namespace
@@ -68,3 +180,16 @@ type Route struct {
 	FQDN   string         `yaml:"fqdn,omitempty"`
 	Routes []RouteInclude `yaml:"routes,omitempty"`
 }
+
+// ReleaseSplit is the share of traffic, in percent, a function gets
+type ReleaseSplit struct {
+	Function string `yaml:"function"`
+	Weight   int    `yaml:"weight"`
+}
+
+// Release splits the traffic of the routes of a function across its versions,
+// steps are the weights ccb promote moves through
+type Release struct {
+	Steps  []int          `yaml:"steps,omitempty"`
+	Splits []ReleaseSplit `yaml:"splits"`
+}
@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+// ErrFunctionExists when a function is added with a key that's taken
+var ErrFunctionExists = errors.New("function already exists")
+
+const defaultIndent = 2
+
+// AddFunction adds a function to a local stack file. The new entry is spliced
+// into the text so comments, ordering and spacing of the file are kept.
+func AddFunction(filename string, key string, template string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	root, err := parseNode(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	fnKey, fns := mappingValue(root, "functions")
+
+	var at int
+	var entry string
+	switch {
+	case fns == nil:
+		// no functions yet, add the section at the end
+		at = len(lines)
+		if at > 0 && lines[at-1] == "" {
+			at--
+		}
+		out, err := renderFunction(key, template, defaultIndent)
+		if err != nil {
+			return err
+		}
+		entry = "\nfunctions:\n" + indent(out, defaultIndent)
+
+	case fns.Kind == yaml.ScalarNode && fns.Tag == "!!null" && fns.Value == "":
+		at = fnKey.Line
+		out, err := renderFunction(key, template, defaultIndent)
+		if err != nil {
+			return err
+		}
+		entry = indent(out, defaultIndent)
+
+	case fns.Kind == yaml.MappingNode && fns.Style&yaml.FlowStyle == 0 && len(fns.Content) > 0:
+		if _, v := mappingValue(fns, key); v != nil {
+			return fmt.Errorf("%w: %s", ErrFunctionExists, key)
+		}
+
+		keyIndent := fns.Content[0].Column - 1
+		childIndent := defaultIndent
+		if first := fns.Content[1]; first.Kind == yaml.MappingNode && first.Style&yaml.FlowStyle == 0 && len(first.Content) > 0 {
+			childIndent = first.Content[0].Column - 1 - keyIndent
+		}
+
+		out, err := renderFunction(key, template, childIndent)
+		if err != nil {
+			return err
+		}
+		entry = indent(out, keyIndent)
+
+		// keep the blank line between functions when the file has one
+		if len(fns.Content) >= 4 {
+			second := fns.Content[2].Line
+			if second >= 2 && strings.TrimSpace(lines[second-2]) == "" {
+				entry = "\n" + entry
+			}
+		}
+		at = lastLine(fns)
+
+	default:
+		return fmt.Errorf("%s: functions must be a block mapping", filename)
+	}
+
+	entryLines := strings.Split(strings.TrimSuffix(entry, "\n"), "\n")
+	out := make([]string, 0, len(lines)+len(entryLines))
+	out = append(out, lines[:at]...)
+	out = append(out, entryLines...)
+	out = append(out, lines[at:]...)
+
+	result := strings.Join(out, "\n")
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	return os.WriteFile(filename, []byte(result), info.Mode().Perm())
+}
+
+// SetReleaseWeights changes the weights of a release in a local stack file.
+// Only the numbers are replaced so the rest of the file is left alone.
+func SetReleaseWeights(filename string, release string, splits []manifests.ReleaseSplit) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	root, err := parseNode(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	_, releases := mappingValue(root, "releases")
+	_, r := mappingValue(releases, release)
+	_, list := mappingValue(r, "splits")
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s: release %s has no splits", filename, release)
+	}
+
+	weights := make(map[string]int)
+	for _, s := range splits {
+		weights[s.Function] = s.Weight
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for _, item := range list.Content {
+		_, fn := mappingValue(item, "function")
+		_, weight := mappingValue(item, "weight")
+		if fn == nil || weight == nil {
+			return fmt.Errorf("%s: release %s splits need a function and a weight", filename, release)
+		}
+		value, ok := weights[fn.Value]
+		if !ok {
+			continue
+		}
+		if weight.Kind != yaml.ScalarNode || weight.Style != 0 {
+			return fmt.Errorf("%s: weight of %s must be a plain number", filename, fn.Value)
+		}
+
+		// columns count runes, not bytes
+		line := []rune(lines[weight.Line-1])
+		start := weight.Column - 1
+		end := start + len([]rune(weight.Value))
+		if end > len(line) || string(line[start:end]) != weight.Value {
+			return fmt.Errorf("%s: unable to find the weight of %s", filename, fn.Value)
+		}
+		lines[weight.Line-1] = string(line[:start]) + fmt.Sprint(value) + string(line[end:])
+	}
+
+	return os.WriteFile(filename, []byte(strings.Join(lines, "\n")), info.Mode().Perm())
+}
+
+// parseNode parses a yaml document and returns its root mapping
+func parseNode(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("stack must be a mapping")
+	}
+	return doc.Content[0], nil
+}
+
+// mappingValue finds the key and value nodes of a key in a mapping
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// lastLine is the last line a node spans in the file, 1 based
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	if node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		last += strings.Count(strings.TrimRight(node.Value, "\n"), "\n") + 1
+	}
+	for _, child := range node.Content {
+		if l := lastLine(child); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+func renderFunction(key string, template string, childIndent int) (string, error) {
+	str := func(v string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	}
+
+	node := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			str(key),
+			{
+				Kind: yaml.MappingNode,
+				Content: []*yaml.Node{
+					str("name"), str(key),
+					str("version"), str("0.1"),
+					str("template"), str(template),
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(childIndent)
+	if err := enc.Encode(node); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func indent(text string, n int) string {
+	prefix := strings.Repeat(" ", n)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
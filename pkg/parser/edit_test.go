@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testStack = `# demo stack
+provider:
+  version: 0.2
+
+functions:
+  # the api
+  api:
+    name: api
+    version: 0.1
+    template: golang
+    build_args:
+      LDFLAGS: |
+        -w -s
+
+  worker:
+    name: worker
+    version: 0.1
+    template: golang # pinned later
+
+routes:
+  demo:
+    fqdn: demo.com
+`
+
+const expectedStack = `# demo stack
+provider:
+  version: 0.2
+
+functions:
+  # the api
+  api:
+    name: api
+    version: 0.1
+    template: golang
+    build_args:
+      LDFLAGS: |
+        -w -s
+
+  worker:
+    name: worker
+    version: 0.1
+    template: golang # pinned later
+
+  jobs:
+    name: jobs
+    version: "0.1"
+    template: golang@v1.0.0
+
+routes:
+  demo:
+    fqdn: demo.com
+`
+
+func Test_AddFunction(t *testing.T) {
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte(testStack), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := AddFunction(filename, "jobs", "golang@v1.0.0"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := os.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(out) != expectedStack {
+		t.Errorf("Invalid stack:\n%s", out)
+		return
+	}
+
+	stack, err := LoadStack(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fns, err := stack.GetFunctions("jobs")
+	if err != nil || len(fns) != 1 || fns[0].Template != "golang@v1.0.0" {
+		t.Errorf("Invalid function: %v %v", fns, err)
+	}
+
+	if err := AddFunction(filename, "api", "golang"); !errors.Is(err, ErrFunctionExists) {
+		t.Errorf("Expected function to exist: %v", err)
+	}
+}
+
+func Test_AddFunctionSection(t *testing.T) {
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte("provider:\n  version: 0.2\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := AddFunction(filename, "api", "golang"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := os.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "provider:\n  version: 0.2\n\nfunctions:\n  api:\n    name: api\n    version: \"0.1\"\n    template: golang\n"
+	if string(out) != expected {
+		t.Errorf("Invalid stack:\n%s", out)
+	}
+}
+
+func Test_SetReleaseWeights(t *testing.T) {
+	const releaseStack = `provider:
+  version: 0.2
+
+releases:
+  api:
+    splits:
+      - function: api_1 # stable
+        weight: 90
+      - {function: api_2, weight: 10}
+
+functions:
+  api_1:
+    name: api
+    version: 0.1
+  api_2:
+    name: api
+    version: 0.2
+`
+
+	filename := path.Join(t.TempDir(), "stack.yml")
+	if err := os.WriteFile(filename, []byte(releaseStack), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stack, err := LoadStack(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	releases, err := stack.GetReleases()
+	if err != nil || len(releases) != 1 {
+		t.Errorf("Invalid releases: %v %v", releases, err)
+		return
+	}
+
+	splits, err := releases[0].Promote("api_2", 25)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := SetReleaseWeights(filename, "api", splits); err != nil {
+		t.Error(err)
+		return
+	}
+
+	out, err := os.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := strings.Replace(strings.Replace(releaseStack, "weight: 90", "weight: 75", 1), "weight: 10", "weight: 25", 1)
+	if string(out) != expected {
+		t.Errorf("Invalid stack:\n%s", out)
+	}
+}
@@ -1,11 +1,23 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/go-playground/validator/v10"
 
 	"github.com/contextcloud/ccb/pkg/manifests"
 )
 
+// ErrInvalidRoute when a route of a function can't be rendered
+var ErrInvalidRoute = errors.New("invalid route")
+
+// RouteMethods a route can match on
+var RouteMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
 type Function struct {
 	manifests.Function
 	Key string `validate:"required"`
@@ -27,6 +39,92 @@ func newFunction(key string, raw manifests.Function) (*Function, error) {
 	if err := validator.New().Struct(fn); err != nil {
 		return nil, err
 	}
+	for _, r := range fn.Routes {
+		if err := validateRoute(r); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
 
 	return fn, nil
 }
+
+// validateRoute checks what the routers can't, the route is named by its
+// prefix in the errors
+func validateRoute(r manifests.FunctionRoute) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalidRoute, r.Prefix, fmt.Sprintf(format, args...))
+	}
+
+	if !strings.HasPrefix(r.Prefix, "/") {
+		return invalid("prefix must start with /")
+	}
+
+	for _, h := range r.Headers {
+		if h.Name == "" {
+			return invalid("headers need a name")
+		}
+		switch h.Operator {
+		case "", manifests.HeaderEquals, manifests.HeaderNotEquals:
+		case manifests.HeaderRegex:
+			if _, err := regexp.Compile(h.Value); err != nil {
+				return invalid("header %s: %s", h.Name, err)
+			}
+		default:
+			return invalid("header %s op must be %s, %s or %s", h.Name, manifests.HeaderEquals, manifests.HeaderNotEquals, manifests.HeaderRegex)
+		}
+	}
+
+	for _, m := range r.Methods {
+		if !isMethod(m) {
+			return invalid("method %s must be one of %s", m, strings.Join(RouteMethods, ", "))
+		}
+	}
+
+	// a redirect never reaches the function
+	if r.Redirect != "" && (r.Rewrite != "" || r.Timeout != "" || r.CORS != nil || r.RateLimit != nil) {
+		return invalid("redirects can't have a rewrite, timeout, cors or rate limit")
+	}
+
+	if r.Rewrite != "" && !strings.HasPrefix(r.Rewrite, "/") {
+		return invalid("rewrite must start with /")
+	}
+
+	if r.Timeout != "" {
+		d, err := time.ParseDuration(r.Timeout)
+		if err != nil {
+			return invalid("timeout: %s", err)
+		}
+		if d < time.Second {
+			return invalid("timeout must be at least 1s")
+		}
+	}
+
+	if r.CORS != nil {
+		if r.CORS.Origin == "" {
+			return invalid("cors needs an origin")
+		}
+		for _, m := range r.CORS.Methods {
+			if !isMethod(m) {
+				return invalid("cors method %s must be one of %s", m, strings.Join(RouteMethods, ", "))
+			}
+		}
+		if r.CORS.MaxAge < 0 {
+			return invalid("cors max_age can't be negative")
+		}
+	}
+
+	if r.RateLimit != nil && (r.RateLimit.Rate < 1 || r.RateLimit.Burst < 0) {
+		return invalid("rate limit needs a rate of at least 1 and a burst that isn't negative")
+	}
+
+	return nil
+}
+
+func isMethod(m string) bool {
+	for _, valid := range RouteMethods {
+		if m == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+func Test_ValidateRoute(t *testing.T) {
+	invalid := []manifests.FunctionRoute{
+		{Prefix: "api"},
+		{Prefix: "/api", Headers: []manifests.RouteHeader{{Value: "x"}}},
+		{Prefix: "/api", Headers: []manifests.RouteHeader{{Name: "X-Tenant", Operator: "gt", Value: "x"}}},
+		{Prefix: "/api", Headers: []manifests.RouteHeader{{Name: "X-Tenant", Operator: manifests.HeaderRegex, Value: "("}}},
+		{Prefix: "/api", Methods: []string{"get"}},
+		{Prefix: "/api", Redirect: "www.demo.com", Rewrite: "/"},
+		{Prefix: "/api", Rewrite: "v2"},
+		{Prefix: "/api", Timeout: "soon"},
+		{Prefix: "/api", Timeout: "10ms"},
+		{Prefix: "/api", CORS: &manifests.RouteCORS{}},
+		{Prefix: "/api", RateLimit: &manifests.RouteRateLimit{}},
+	}
+	for i, r := range invalid {
+		if err := validateRoute(r); !errors.Is(err, ErrInvalidRoute) {
+			t.Errorf("%d: expected ErrInvalidRoute, got %v", i, err)
+		}
+	}
+
+	valid := manifests.FunctionRoute{
+		Prefix:    "/api",
+		Headers:   []manifests.RouteHeader{{Name: "X-Tenant", Operator: manifests.HeaderRegex, Value: "^demo-.*"}},
+		Methods:   []string{"GET", "OPTIONS"},
+		Rewrite:   "/",
+		Timeout:   "1m",
+		CORS:      &manifests.RouteCORS{Origin: "*"},
+		RateLimit: &manifests.RouteRateLimit{Rate: 5},
+	}
+	if err := validateRoute(valid); err != nil {
+		t.Error(err)
+	}
+}
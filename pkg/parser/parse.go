@@ -2,6 +2,7 @@ package parser
 
 import (
 	"io/ioutil"
+	"path/filepath"
 
 	"github.com/contextcloud/ccb/pkg/manifests"
 	"gopkg.in/yaml.v2"
@@ -24,5 +25,10 @@ func LoadStack(yamlFile string) (Stack, error) {
 		return nil, err
 	}
 
-	return NewStack(&raw)
+	// stacks are named after their directory unless they say otherwise
+	abs, err := filepath.Abs(yamlFile)
+	if err != nil {
+		return nil, err
+	}
+	return newStack(&raw, filepath.Base(filepath.Dir(abs)))
 }
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+// ErrInvalidRelease when the splits of a release don't add up
+var ErrInvalidRelease = errors.New("invalid release")
+
+// DefaultSteps are the weights a release is promoted through when it has none
+var DefaultSteps = []int{10, 25, 50, 100}
+
+type Release struct {
+	manifests.Release
+	Key string `validate:"required"`
+}
+
+func newRelease(key string, raw manifests.Release, fns map[string]manifests.Function) (*Release, error) {
+	r := &Release{
+		Release: raw,
+		Key:     key,
+	}
+
+	// validate it!
+	if err := validator.New().Struct(r); err != nil {
+		return nil, err
+	}
+
+	if len(r.Splits) < 2 {
+		return nil, fmt.Errorf("%w: %s needs at least two splits", ErrInvalidRelease, key)
+	}
+
+	total := 0
+	seen := make(map[string]bool)
+	for _, s := range r.Splits {
+		if _, ok := fns[s.Function]; !ok {
+			return nil, fmt.Errorf("%w: %s splits to function %s which isn't in the stack", ErrInvalidRelease, key, s.Function)
+		}
+		if seen[s.Function] {
+			return nil, fmt.Errorf("%w: %s splits to function %s twice", ErrInvalidRelease, key, s.Function)
+		}
+		if s.Weight < 0 || s.Weight > 100 {
+			return nil, fmt.Errorf("%w: %s weight of %s must be between 0 and 100", ErrInvalidRelease, key, s.Function)
+		}
+		seen[s.Function] = true
+		total += s.Weight
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("%w: %s weights add up to %d instead of 100", ErrInvalidRelease, key, total)
+	}
+
+	for i, step := range r.Steps {
+		if step < 1 || step > 100 || (i > 0 && step <= r.Steps[i-1]) {
+			return nil, fmt.Errorf("%w: %s steps must go up from 1 to 100", ErrInvalidRelease, key)
+		}
+	}
+
+	return r, nil
+}
+
+// Has the function as one of its splits
+func (r *Release) Has(key string) bool {
+	for _, s := range r.Splits {
+		if s.Function == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Weight the function gets
+func (r *Release) Weight(key string) (int, error) {
+	for _, s := range r.Splits {
+		if s.Function == key {
+			return s.Weight, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s doesn't split to %s", ErrInvalidRelease, r.Key, key)
+}
+
+// NextStep is the first step above the weight the function has
+func (r *Release) NextStep(key string) (int, error) {
+	weight, err := r.Weight(key)
+	if err != nil {
+		return 0, err
+	}
+
+	steps := r.Steps
+	if len(steps) == 0 {
+		steps = DefaultSteps
+	}
+	for _, step := range steps {
+		if step > weight {
+			return step, nil
+		}
+	}
+	if weight < 100 {
+		return 100, nil
+	}
+	return 0, fmt.Errorf("%w: %s already sends everything to %s", ErrInvalidRelease, r.Key, key)
+}
+
+// Promote gives the function a weight, the other functions share what's left
+// in the same proportion as before
+func (r *Release) Promote(key string, weight int) ([]manifests.ReleaseSplit, error) {
+	if weight < 0 || weight > 100 {
+		return nil, fmt.Errorf("%w: %s weight must be between 0 and 100", ErrInvalidRelease, r.Key)
+	}
+	current, err := r.Weight(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := 100 - weight
+	others := 100 - current
+
+	out := make([]manifests.ReleaseSplit, len(r.Splits))
+	var order []int
+	left := rest
+	for i, s := range r.Splits {
+		out[i] = s
+		if s.Function == key {
+			out[i].Weight = weight
+			continue
+		}
+		switch {
+		case others > 0:
+			out[i].Weight = s.Weight * rest / others
+		default:
+			// the others had nothing, share it evenly
+			out[i].Weight = rest / (len(r.Splits) - 1)
+		}
+		left -= out[i].Weight
+		order = append(order, i)
+	}
+
+	// the rounding goes to the biggest of the others so it's still 100
+	sort.SliceStable(order, func(i, j int) bool {
+		return out[order[i]].Weight > out[order[j]].Weight
+	})
+	if len(order) > 0 {
+		out[order[0]].Weight += left
+	}
+	return out, nil
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+func Test_Release(t *testing.T) {
+	fns := map[string]manifests.Function{"a": {}, "b": {}, "c": {}}
+
+	invalid := []manifests.Release{
+		{Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 100}}},
+		{Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 90}, {Function: "b", Weight: 20}}},
+		{Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 90}, {Function: "x", Weight: 10}}},
+		{Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 90}, {Function: "a", Weight: 10}}},
+		{Steps: []int{50, 10}, Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 90}, {Function: "b", Weight: 10}}},
+	}
+	for i, raw := range invalid {
+		if _, err := newRelease("r", raw, fns); !errors.Is(err, ErrInvalidRelease) {
+			t.Errorf("%d: expected ErrInvalidRelease, got %v", i, err)
+		}
+	}
+
+	r, err := newRelease("r", manifests.Release{
+		Splits: []manifests.ReleaseSplit{{Function: "a", Weight: 60}, {Function: "b", Weight: 30}, {Function: "c", Weight: 10}},
+	}, fns)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	next, err := r.NextStep("c")
+	if err != nil || next != 25 {
+		t.Errorf("expected the next step to be 25, got %d %v", next, err)
+	}
+
+	splits, err := r.Promote("c", 25)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// a and b share the 75 that is left 2:1
+	if splits[0].Weight != 50 || splits[1].Weight != 25 || splits[2].Weight != 25 {
+		t.Errorf("unexpected splits %+v", splits)
+	}
+
+	r.Splits = splits
+	splits, err = r.Promote("a", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if splits[0].Weight != 100 || splits[1].Weight != 0 || splits[2].Weight != 0 {
+		t.Errorf("unexpected splits %+v", splits)
+	}
+
+	r.Splits = splits
+	splits, err = r.Promote("b", 50)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if splits[0].Weight != 50 || splits[1].Weight != 50 || splits[2].Weight != 0 {
+		t.Errorf("unexpected splits %+v", splits)
+	}
+}
@@ -1,8 +1,6 @@
 package parser
 
 import (
-	"fmt"
-
 	"github.com/go-playground/validator/v10"
 
 	"github.com/contextcloud/ccb/pkg/manifests"
@@ -19,8 +17,6 @@ func newRoute(key string, raw manifests.Route) (*Route, error) {
 		Key:   key,
 	}
 
-	fmt.Println(raw)
-
 	// validate it!
 	if err := validator.New().Struct(r); err != nil {
 		return nil, err
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+// ErrInvalidRouter when the router isn't one ccb can render for
+var ErrInvalidRouter = errors.New("invalid router")
+
+// ValidRouters the routes can be rendered for
+var ValidRouters = []string{
+	manifests.RouterNginx,
+	manifests.RouterGatewayAPI,
+	manifests.RouterIngress,
+}
+
+// ValidateRouter checks the kind and the gateway, empty is the default
+func ValidateRouter(r manifests.Router) error {
+	if r.Kind != "" {
+		valid := false
+		for _, kind := range ValidRouters {
+			valid = valid || kind == r.Kind
+		}
+		if !valid {
+			return fmt.Errorf("%w: %s, use one of %s", ErrInvalidRouter, r.Kind, strings.Join(ValidRouters, ", "))
+		}
+	}
+	if strings.Count(r.Gateway, "/") > 1 {
+		return fmt.Errorf("%w: gateway %s must be namespace/name or name", ErrInvalidRouter, r.Gateway)
+	}
+	switch r.IssuerKind {
+	case "", "Issuer", "ClusterIssuer":
+	default:
+		return fmt.Errorf("%w: issuer kind %s must be Issuer or ClusterIssuer", ErrInvalidRouter, r.IssuerKind)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+// ErrInvalidScheduling when the pods can't be scheduled like that
+var ErrInvalidScheduling = errors.New("invalid scheduling")
+
+// ValidateScheduling checks the spread and tolerations, nil is the default
+func ValidateScheduling(s *manifests.Scheduling) error {
+	if s == nil {
+		return nil
+	}
+
+	switch s.Spread {
+	case "", manifests.SpreadNone, manifests.SpreadDoNotSchedule, manifests.SpreadScheduleAnyway:
+	default:
+		return fmt.Errorf("%w: spread %s must be %s, %s or %s", ErrInvalidScheduling, s.Spread, manifests.SpreadNone, manifests.SpreadDoNotSchedule, manifests.SpreadScheduleAnyway)
+	}
+
+	for _, t := range s.Tolerations {
+		switch t.Operator {
+		case "", "Equal":
+		case "Exists":
+			if t.Value != "" {
+				return fmt.Errorf("%w: toleration %s exists can't have a value", ErrInvalidScheduling, t.Key)
+			}
+		default:
+			return fmt.Errorf("%w: toleration %s operator %s must be Equal or Exists", ErrInvalidScheduling, t.Key, t.Operator)
+		}
+		switch t.Effect {
+		case "", "NoSchedule", "PreferNoSchedule":
+			if t.Seconds != nil {
+				return fmt.Errorf("%w: toleration %s seconds are only for NoExecute", ErrInvalidScheduling, t.Key)
+			}
+		case "NoExecute":
+		default:
+			return fmt.Errorf("%w: toleration %s effect %s must be NoSchedule, PreferNoSchedule or NoExecute", ErrInvalidScheduling, t.Key, t.Effect)
+		}
+	}
+	return nil
+}
+
+// mergeScheduling of a function over the defaults of the stack, each setting
+// the function has replaces the default so an empty node_selector clears it
+func mergeScheduling(defaults manifests.Scheduling, fn *manifests.Scheduling) *manifests.Scheduling {
+	out := defaults
+	if fn == nil {
+		return &out
+	}
+	if fn.NodeSelector != nil {
+		out.NodeSelector = fn.NodeSelector
+	}
+	if fn.Tolerations != nil {
+		out.Tolerations = fn.Tolerations
+	}
+	if fn.Affinity != nil {
+		out.Affinity = fn.Affinity
+	}
+	if fn.Spread != "" {
+		out.Spread = fn.Spread
+	}
+	if fn.PriorityClass != "" {
+		out.PriorityClass = fn.PriorityClass
+	}
+	return &out
+}
@@ -1,19 +1,37 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/contextcloud/ccb/pkg/manifests"
 	"github.com/ryanuber/go-glob"
 )
 
+var (
+	// ErrInvalidCall when a function calls one that isn't in the stack
+	ErrInvalidCall = errors.New("invalid call")
+	// ErrUnnamedStack when the stack file doesn't name the stack
+	ErrUnnamedStack = errors.New("stack has no name")
+)
+
 type Stack interface {
+	GetName() string
+	IsNamed() bool
 	GetRoutes(filters ...string) ([]*Route, error)
 	GetFunctions(filters ...string) ([]*Function, error)
+	GetReleases(filters ...string) ([]*Release, error)
+	GetTemplateSources() map[string]manifests.TemplateSource
+	GetRouter() manifests.Router
+	GetNetwork() manifests.Network
+	GetCallers() map[string][]string
 }
 
 type stack struct {
 	raw *manifests.Stack
+	// dirName names the stack when the file doesn't
+	dirName string
 }
 
 func (s *stack) isMatch(name string, filters []string) bool {
@@ -47,6 +65,10 @@ func (s *stack) GetRoutes(filters ...string) ([]*Route, error) {
 		routes = append(routes, route)
 	}
 
+	// maps don't keep an order, sort so what's rendered is the same every time
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Key < routes[j].Key
+	})
 	return routes, nil
 
 }
@@ -56,6 +78,7 @@ func (s *stack) GetFunctions(filters ...string) ([]*Function, error) {
 
 	// filter using input
 	for k, raw := range s.raw.Functions {
+		raw.Scheduling = mergeScheduling(s.raw.Scheduling, raw.Scheduling)
 		fn, err := newFunction(k, raw)
 		if err != nil {
 			return nil, err
@@ -68,14 +91,109 @@ func (s *stack) GetFunctions(filters ...string) ([]*Function, error) {
 		fns = append(fns, fn)
 	}
 
+	sort.Slice(fns, func(i, j int) bool {
+		return fns[i].Key < fns[j].Key
+	})
 	return fns, nil
 }
 
+func (s *stack) GetReleases(filters ...string) ([]*Release, error) {
+	var releases []*Release
+
+	// a function can only be in one release or the splits would fight
+	owners := make(map[string]string)
+	for k, raw := range s.raw.Releases {
+		release, err := newRelease(k, raw, s.raw.Functions)
+		if err != nil {
+			return nil, err
+		}
+		for _, split := range release.Splits {
+			if other, ok := owners[split.Function]; ok {
+				return nil, fmt.Errorf("%w: function %s is in releases %s and %s", ErrInvalidRelease, split.Function, other, k)
+			}
+			owners[split.Function] = k
+		}
+
+		if !s.isMatch(release.Key, filters) {
+			continue
+		}
+
+		releases = append(releases, release)
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Key < releases[j].Key
+	})
+	return releases, nil
+}
+
+// GetName of the stack, it identifies what the stack deployed
+func (s *stack) GetName() string {
+	if s.raw.Name == "" {
+		return s.dirName
+	}
+	return s.raw.Name
+}
+
+// IsNamed when the stack file sets the name, a name taken from the directory
+// is shared by any stack in a directory called the same
+func (s *stack) IsNamed() bool {
+	return s.raw.Name != ""
+}
+
+func (s *stack) GetTemplateSources() map[string]manifests.TemplateSource {
+	return s.raw.Templates
+}
+
+// GetRouter the routes are rendered for
+func (s *stack) GetRouter() manifests.Router {
+	return s.raw.Router
+}
+
+// GetNetwork policies of the stack
+func (s *stack) GetNetwork() manifests.Network {
+	return s.raw.Network
+}
+
+// GetCallers of each function, the functions that have it in their calls
+func (s *stack) GetCallers() map[string][]string {
+	out := make(map[string][]string)
+	for key, fn := range s.raw.Functions {
+		for _, callee := range fn.Calls {
+			out[callee] = append(out[callee], key)
+		}
+	}
+	for _, callers := range out {
+		sort.Strings(callers)
+	}
+	return out
+}
+
 func NewStack(raw *manifests.Stack) (Stack, error) {
+	return newStack(raw, "")
+}
+
+func newStack(raw *manifests.Stack, dirName string) (Stack, error) {
 	// validate version.
 	if !isValidSchemaVersion(raw.Provider.Version) {
 		return nil, fmt.Errorf("%s are the only valid versions for the stack file - found: %s", ValidSchemaVersions, raw.Provider.Version)
 	}
+	if err := ValidateRouter(raw.Router); err != nil {
+		return nil, err
+	}
+	if err := ValidateScheduling(&raw.Scheduling); err != nil {
+		return nil, err
+	}
+	for key, fn := range raw.Functions {
+		if err := ValidateScheduling(fn.Scheduling); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		for _, callee := range fn.Calls {
+			if _, ok := raw.Functions[callee]; !ok || callee == key {
+				return nil, fmt.Errorf("%w: %s calls %s which isn't another function of the stack", ErrInvalidCall, key, callee)
+			}
+		}
+	}
 
-	return &stack{raw}, nil
+	return &stack{raw, dirName}, nil
 }
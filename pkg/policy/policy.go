@@ -0,0 +1,187 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/go-units"
+	"github.com/ryanuber/go-glob"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	RuleNonRoot           = "non_root"
+	RuleAllowedBaseImages = "allowed_base_images"
+	RuleMaxSize           = "max_size"
+	RuleRequiredLabels    = "required_labels"
+)
+
+// Policy images must pass before they're pushed
+type Policy struct {
+	NonRoot           bool     `yaml:"non_root,omitempty"`
+	AllowedBaseImages []string `yaml:"allowed_base_images,omitempty"`
+	MaxSize           string   `yaml:"max_size,omitempty"`
+	RequiredLabels    []string `yaml:"required_labels,omitempty"`
+
+	maxSize int64
+}
+
+// Image is what the policy knows about a built image, BaseImage is empty
+// when it couldn't be worked out and Scratch when there is none
+type Image struct {
+	Name      string
+	User      string
+	Size      int64
+	BaseImage string
+	Scratch   bool
+	Labels    map[string]string
+}
+
+// Violation of a single rule
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Error lists the violations for a function
+type Error struct {
+	Function   string
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: image failed policy", e.Function)
+	for _, v := range e.Violations {
+		fmt.Fprintf(&sb, "\n  %s: %s", v.Rule, v.Message)
+	}
+	return sb.String()
+}
+
+// Load the policy file, a missing file means there is no policy
+func Load(filename string) (*Policy, error) {
+	out, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(out, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if p.MaxSize != "" {
+		// accept kubernetes style quantities like 500Mi
+		maxSize := p.MaxSize
+		if strings.HasSuffix(maxSize, "i") {
+			maxSize += "B"
+		}
+		size, err := units.RAMInBytes(maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %w", filename, RuleMaxSize, err)
+		}
+		p.maxSize = size
+	}
+
+	return &p, nil
+}
+
+// Evaluate the image against every rule
+func (p *Policy) Evaluate(img *Image) []Violation {
+	var out []Violation
+
+	if p.NonRoot && isRoot(img.User) {
+		user := img.User
+		if user == "" {
+			user = "root (default)"
+		}
+		out = append(out, Violation{
+			Rule:    RuleNonRoot,
+			Message: fmt.Sprintf("runs as %s", user),
+		})
+	}
+
+	if len(p.AllowedBaseImages) > 0 && !img.Scratch {
+		switch {
+		case img.BaseImage == "":
+			out = append(out, Violation{
+				Rule:    RuleAllowedBaseImages,
+				Message: "base image could not be determined",
+			})
+		case !p.isAllowedBase(img.BaseImage):
+			out = append(out, Violation{
+				Rule:    RuleAllowedBaseImages,
+				Message: fmt.Sprintf("base image %s is not allowed", img.BaseImage),
+			})
+		}
+	}
+
+	if p.maxSize > 0 && img.Size > p.maxSize {
+		out = append(out, Violation{
+			Rule:    RuleMaxSize,
+			Message: fmt.Sprintf("size %s is over %s", units.BytesSize(float64(img.Size)), p.MaxSize),
+		})
+	}
+
+	var missing []string
+	for _, label := range p.RequiredLabels {
+		if v, ok := img.Labels[label]; !ok || v == "" {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		out = append(out, Violation{
+			Rule:    RuleRequiredLabels,
+			Message: fmt.Sprintf("missing labels %s", strings.Join(missing, ", ")),
+		})
+	}
+
+	return out
+}
+
+func (p *Policy) isAllowedBase(image string) bool {
+	image = normalize(image)
+	for _, pattern := range p.AllowedBaseImages {
+		if glob.Glob(normalize(pattern), image) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize an image or a pattern so golang:1.22 is
+// docker.io/library/golang:1.22, patterns aren't valid references so they're
+// given the docker hub domain the same way
+func normalize(image string) string {
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		return named.String()
+	}
+
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return "docker.io/library/" + image
+	}
+	domain := image[:i]
+	if domain == "index.docker.io" {
+		return normalize("docker.io" + image[i:])
+	}
+	if domain == "docker.io" && !strings.Contains(image[i+1:], "/") {
+		return "docker.io/library/" + image[i+1:]
+	}
+	if !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		return "docker.io/" + image
+	}
+	return image
+}
+
+func isRoot(user string) bool {
+	name := strings.SplitN(user, ":", 2)[0]
+	return name == "" || name == "root" || name == "0"
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+const testPolicy = `non_root: true
+allowed_base_images:
+  - gcr.io/distroless/*
+max_size: 100Mi
+required_labels:
+  - org.opencontainers.image.source
+  - org.opencontainers.image.revision
+`
+
+func Test_Evaluate(t *testing.T) {
+	filename := path.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(filename, []byte(testPolicy), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	p, err := Load(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	passing := &Image{
+		Name:      "profile:latest",
+		User:      "nonroot:nonroot",
+		Size:      20 << 20,
+		BaseImage: "gcr.io/distroless/static:nonroot",
+		Labels: map[string]string{
+			"org.opencontainers.image.source":   "https://github.com/contextcloud/examples",
+			"org.opencontainers.image.revision": "abc123",
+		},
+	}
+	if violations := p.Evaluate(passing); len(violations) != 0 {
+		t.Errorf("Unexpected violations: %v", violations)
+		return
+	}
+
+	failing := &Image{
+		Name:      "profile:latest",
+		User:      "0:0",
+		Size:      200 << 20,
+		BaseImage: "alpine:3.19",
+		Labels: map[string]string{
+			"org.opencontainers.image.source": "https://github.com/contextcloud/examples",
+		},
+	}
+	violations := p.Evaluate(failing)
+
+	rules := make(map[string]bool)
+	for _, v := range violations {
+		rules[v.Rule] = true
+	}
+	for _, rule := range []string{RuleNonRoot, RuleAllowedBaseImages, RuleMaxSize, RuleRequiredLabels} {
+		if !rules[rule] {
+			t.Errorf("Missing violation: %s", rule)
+		}
+	}
+
+	t.Log((&Error{Function: "profile", Violations: violations}).Error())
+}
+
+func Test_AllowedBaseImages(t *testing.T) {
+	p := &Policy{AllowedBaseImages: []string{"golang:1.22", "docker.io/library/alpine:*", "gcr.io/distroless/*"}}
+
+	images := map[string]bool{
+		"golang:1.22":                         true,
+		"docker.io/library/golang:1.22":       true,
+		"index.docker.io/library/golang:1.22": true,
+		"alpine:3.19":                         true,
+		"gcr.io/distroless/static:nonroot":    true,
+		"golang:1.21":                         false,
+		"docker.io/someone/golang:1.22":       false,
+		"":                                    false,
+	}
+	for image, allowed := range images {
+		violations := p.Evaluate(&Image{BaseImage: image})
+		if allowed != (len(violations) == 0) {
+			t.Errorf("%s: unexpected violations %v", image, violations)
+		}
+	}
+
+	// an arg that wasn't set
+	violations := p.Evaluate(&Image{})
+	if len(violations) != 1 || violations[0].Message != "base image could not be determined" {
+		t.Errorf("Unexpected violations: %v", violations)
+	}
+	if violations := p.Evaluate(&Image{Scratch: true}); len(violations) != 0 {
+		t.Errorf("Unexpected violations for scratch: %v", violations)
+	}
+}
+
+func Test_LoadMissing(t *testing.T) {
+	p, err := Load(path.Join(t.TempDir(), "policy.yaml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if p != nil {
+		t.Error("Expected no policy")
+	}
+}
@@ -1,11 +1,14 @@
 package print
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sync"
 )
 
 type Log interface {
+	io.Writer
 	Printf(format string, a ...interface{})
 	Print(a ...interface{})
 	Println(a ...interface{})
@@ -30,3 +33,36 @@ func (o log) Println(a ...interface{}) {
 func NewLog(w io.Writer) Log {
 	return log{w}
 }
+
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+// Write whole lines with the prefix, partial lines wait for the rest
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf.Write(b)
+	for {
+		line, err := p.buf.ReadBytes('\n')
+		if err != nil {
+			// put the partial line back
+			rest := append([]byte{}, line...)
+			p.buf.Reset()
+			p.buf.Write(rest)
+			return len(b), nil
+		}
+		if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// NewPrefixLog writes every line with a prefix, e.g. the function name
+func NewPrefixLog(w io.Writer, prefix string) Log {
+	return log{&prefixWriter{w: w, prefix: prefix}}
+}
@@ -0,0 +1,306 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/contextcloud/ccb/pkg/deployer"
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/contextcloud/ccb/pkg/parser"
+)
+
+var (
+	// ErrRouteConflict when two routes claim the same requests
+	ErrRouteConflict = errors.New("route conflict")
+	// ErrRouteShadowed when a route takes part of what another one serves
+	ErrRouteShadowed = errors.New("route shadowed")
+	// ErrRouteUnreachable when the route is never sent a request
+	ErrRouteUnreachable = errors.New("route unreachable")
+	// ErrRedirectLoop when redirects end up where they started
+	ErrRedirectLoop = errors.New("redirect loop")
+	// ErrMissingFunction when an include has no function routes to send to
+	ErrMissingFunction = errors.New("no function for route")
+	// ErrMissingInclude when an include doesn't say where it goes
+	ErrMissingInclude = errors.New("include target not declared")
+)
+
+// maxRedirects followed looking for a loop
+const maxRedirects = 10
+
+// Problem in a route table, warnings are worth knowing about but the routes
+// still work
+type Problem struct {
+	Err     error
+	Warning bool
+	Host    string
+	Prefix  string
+	Message string
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s%s: %s: %s", p.Host, p.Prefix, p.Err, p.Message)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// Analyze the routes of a stack, fns has to be all of its functions or the
+// includes can't be checked
+func Analyze(routes []*parser.Route, fns []*parser.Function, releases []*parser.Release) []*Problem {
+	table := BuildTable(routes, fns, releases)
+
+	var out []*Problem
+	out = append(out, conflicts(table)...)
+	out = append(out, shadowed(table)...)
+	out = append(out, redirectLoops(table)...)
+	out = append(out, includes(routes, fns)...)
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Warning != out[j].Warning {
+			return !out[i].Warning
+		}
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		return out[i].Prefix < out[j].Prefix
+	})
+	return out
+}
+
+// Target of a route like the table prints it
+func (rt *Route) Target() string {
+	switch {
+	case rt.Redirect != "":
+		return "redirect " + rt.Redirect
+	case rt.Namespace != "":
+		return rt.Namespace + "/" + rt.Name
+	case len(rt.Splits) > 0:
+		var splits []string
+		for _, s := range rt.Splits {
+			splits = append(splits, fmt.Sprintf("%s %d%%", s.Function, s.Weight))
+		}
+		return strings.Join(splits, ", ")
+	}
+	return rt.Function
+}
+
+// describe the route with its name when it has one
+func (rt *Route) describe() string {
+	if rt.Name == "" || rt.Namespace != "" {
+		return rt.Target()
+	}
+	return fmt.Sprintf("%s (route %s)", rt.Target(), rt.Name)
+}
+
+// Conditions a request has to meet besides the prefix
+func (rt *Route) Conditions() string {
+	var out []string
+	if len(rt.Methods) > 0 {
+		out = append(out, strings.Join(rt.Methods, ","))
+	}
+	for _, h := range rt.Headers {
+		op := "="
+		switch h.Operator {
+		case manifests.HeaderNotEquals:
+			op = "!="
+		case manifests.HeaderRegex:
+			op = "~"
+		}
+		out = append(out, h.Name+op+h.Value)
+	}
+	return strings.Join(out, " ")
+}
+
+// conflicts are routes on the same host and prefix that send the same
+// requests somewhere else
+func conflicts(table []*Route) []*Problem {
+	var out []*Problem
+	for i, a := range table {
+		for _, b := range table[i+1:] {
+			if a.Host != b.Host || a.Prefix != b.Prefix || a.Target() == b.Target() {
+				continue
+			}
+			if !overlap(a, b) {
+				continue
+			}
+			out = append(out, &Problem{
+				Err:     ErrRouteConflict,
+				Host:    a.Host,
+				Prefix:  a.Prefix,
+				Message: fmt.Sprintf("%s and %s both claim it", a.describe(), b.describe()),
+			})
+		}
+	}
+	return out
+}
+
+// overlap when a request could match both routes
+func overlap(a *Route, b *Route) bool {
+	if len(a.Methods) > 0 && len(b.Methods) > 0 {
+		shared := false
+		for _, m := range a.Methods {
+			for _, n := range b.Methods {
+				shared = shared || m == n
+			}
+		}
+		if !shared {
+			return false
+		}
+	}
+
+	for _, h := range a.Headers {
+		for _, k := range b.Headers {
+			if !strings.EqualFold(h.Name, k.Name) || !isEquals(h) || !isEquals(k) {
+				continue
+			}
+			if h.Value != k.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isEquals(h manifests.RouteHeader) bool {
+	return h.Operator == "" || h.Operator == manifests.HeaderEquals
+}
+
+// shadowed are routes of this stack under a prefix another namespace serves,
+// they take those requests from it
+func shadowed(table []*Route) []*Problem {
+	var out []*Problem
+	for _, outer := range table {
+		if outer.Namespace == "" {
+			continue
+		}
+		for _, inner := range table {
+			if inner == outer || inner.Host != outer.Host || inner.Namespace == outer.Namespace {
+				continue
+			}
+			if len(inner.Prefix) <= len(outer.Prefix) || !strings.HasPrefix(inner.Prefix, outer.Prefix) {
+				continue
+			}
+			out = append(out, &Problem{
+				Err:     ErrRouteShadowed,
+				Warning: true,
+				Host:    inner.Host,
+				Prefix:  inner.Prefix,
+				Message: fmt.Sprintf("%s takes it from %s which serves %s", inner.describe(), outer.describe(), outer.Prefix),
+			})
+		}
+	}
+	return out
+}
+
+// redirectLoops follow each redirect through the table until it leaves it
+func redirectLoops(table []*Route) []*Problem {
+	var out []*Problem
+	for _, start := range table {
+		if start.Redirect == "" {
+			continue
+		}
+
+		rt := start
+		seen := map[*Route]bool{rt: true}
+		for i := 0; i < maxRedirects; i++ {
+			target := deployer.ParseRedirect(rt.Redirect)
+			host := target.Hostname
+			if host == "" {
+				host = rt.Host
+			}
+			path := target.Path
+			if path == "" {
+				path = "/"
+			}
+
+			rt = matchHost(table, host, path)
+			if rt == nil || rt.Redirect == "" {
+				break
+			}
+			if seen[rt] {
+				out = append(out, &Problem{
+					Err:     ErrRedirectLoop,
+					Host:    start.Host,
+					Prefix:  start.Prefix,
+					Message: fmt.Sprintf("%s comes back to %s%s", start.Redirect, rt.Host, rt.Prefix),
+				})
+				break
+			}
+			seen[rt] = true
+		}
+	}
+	return out
+}
+
+// matchHost is Match without the fallback for unknown hosts
+func matchHost(table []*Route, host string, path string) *Route {
+	var best *Route
+	for _, rt := range table {
+		if rt.Host != host || !strings.HasPrefix(path, rt.Prefix) {
+			continue
+		}
+		if best == nil || len(rt.Prefix) > len(best.Prefix) {
+			best = rt
+		}
+	}
+	return best
+}
+
+// includes of the stack routes go to a namespace, a redirect or the
+// function routes of the same name
+func includes(routes []*parser.Route, fns []*parser.Function) []*Problem {
+	byName := make(map[string][]manifests.FunctionRoute)
+	for _, fn := range fns {
+		for _, r := range fn.Routes {
+			byName[r.Name] = append(byName[r.Name], r)
+		}
+	}
+
+	var out []*Problem
+	for _, r := range routes {
+		for _, include := range r.Routes {
+			problem := func(err error, warning bool, format string, args ...interface{}) {
+				out = append(out, &Problem{
+					Err:     err,
+					Warning: warning,
+					Host:    r.FQDN,
+					Prefix:  include.Prefix,
+					Message: fmt.Sprintf("%s: ", r.Key) + fmt.Sprintf(format, args...),
+				})
+			}
+
+			switch {
+			case include.Redirect != "":
+				continue
+			case include.Name == "" && include.Namespace != "":
+				problem(ErrMissingInclude, false, "include of namespace %s needs the name of its route", include.Namespace)
+				continue
+			case include.Name == "":
+				problem(ErrMissingInclude, false, "include needs a name, a namespace or a redirect")
+				continue
+			case include.Namespace != "":
+				// served by another stack, there's nothing to check it against
+				continue
+			}
+
+			found, ok := byName[include.Name]
+			if !ok {
+				problem(ErrMissingFunction, false, "no function has a route named %s", include.Name)
+				continue
+			}
+			for _, fr := range found {
+				if fr.FQDN != r.FQDN {
+					problem(ErrRouteUnreachable, true, "route %s at %s is on %s", include.Name, fr.Prefix, fr.FQDN)
+					continue
+				}
+				if !strings.HasPrefix(fr.Prefix, include.Prefix) {
+					problem(ErrRouteUnreachable, true, "route %s at %s is outside of where it's included", include.Name, fr.Prefix)
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/contextcloud/ccb/pkg/parser"
+)
+
+func Test_Analyze(t *testing.T) {
+	api := &parser.Function{Key: "api"}
+	api.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/api"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/assets/mine"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/a", Redirect: "/b"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/b", Redirect: "https://demo.com/a"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/users", Methods: []string{"GET"}},
+	}
+	users := &parser.Function{Key: "users"}
+	users.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/api"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/users", Methods: []string{"POST"}},
+		{Name: "api", FQDN: "other.com", Prefix: "/api/users"},
+	}
+	v1 := &parser.Function{Key: "v1"}
+	v1.Routes = []manifests.FunctionRoute{{Name: "api", FQDN: "demo.com", Prefix: "/v"}}
+	v2 := &parser.Function{Key: "v2"}
+	v2.Routes = []manifests.FunctionRoute{{Name: "v2", FQDN: "demo.com", Prefix: "/v"}}
+
+	releases := []*parser.Release{{Key: "v"}}
+	releases[0].Splits = []manifests.ReleaseSplit{{Function: "v1", Weight: 50}, {Function: "v2", Weight: 50}}
+
+	routes := []*parser.Route{{Key: "demo"}}
+	routes[0].FQDN = "demo.com"
+	routes[0].Routes = []manifests.RouteInclude{
+		{Name: "api", Prefix: "/"},
+		{Name: "assets", Namespace: "other", Prefix: "/assets"},
+		{Namespace: "broken", Prefix: "/broken"},
+		{Name: "missing", Prefix: "/missing"},
+	}
+
+	problems := Analyze(routes, []*parser.Function{api, users, v1, v2}, releases)
+
+	expected := []struct {
+		err     error
+		warning bool
+		prefix  string
+	}{
+		{ErrRedirectLoop, false, "/a"},
+		{ErrRouteConflict, false, "/api"},
+		{ErrRedirectLoop, false, "/b"},
+		{ErrMissingInclude, false, "/broken"},
+		{ErrMissingFunction, false, "/missing"},
+		{ErrRouteUnreachable, true, "/"},
+		{ErrRouteShadowed, true, "/assets/mine"},
+	}
+	if len(problems) != len(expected) {
+		for _, p := range problems {
+			t.Log(p)
+		}
+		t.Errorf("expected %d problems, got %d", len(expected), len(problems))
+		return
+	}
+	for i, e := range expected {
+		p := problems[i]
+		if !errors.Is(p, e.err) || p.Warning != e.warning || p.Prefix != e.prefix {
+			t.Errorf("%d: expected %s at %s, got %s", i, e.err, e.prefix, p)
+		}
+	}
+}
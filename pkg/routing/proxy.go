@@ -0,0 +1,113 @@
+package routing
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// Proxy routes requests to local functions like the ingress does in the cluster
+type Proxy struct {
+	log       print.Log
+	table     []*Route
+	upstreams map[string]*httputil.ReverseProxy
+}
+
+// NewProxy for a route table, upstreams are the base urls of the functions
+func NewProxy(log print.Log, table []*Route, upstreams map[string]string) (*Proxy, error) {
+	p := &Proxy{
+		log:       log,
+		table:     table,
+		upstreams: make(map[string]*httputil.ReverseProxy),
+	}
+
+	for name, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p.upstreams[name] = httputil.NewSingleHostReverseProxy(u)
+	}
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	target := p.serve(rec, r)
+	p.log.Printf("%s %s -> %s %d\n", r.Method, r.URL.Path, target, rec.status)
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) string {
+	rt, status := MatchRequest(p.table, r)
+	switch {
+	case rt == nil:
+		http.Error(w, fmt.Sprintf("no route for %s %s%s", r.Method, r.Host, r.URL.Path), status)
+		return "none"
+	case rt.Redirect != "":
+		http.Redirect(w, r, rt.Redirect, http.StatusMovedPermanently)
+		return rt.Redirect
+	case rt.Namespace != "":
+		http.Error(w, fmt.Sprintf("%s is served by namespace %s which isn't running locally", rt.Prefix, rt.Namespace), http.StatusBadGateway)
+		return rt.Namespace
+	}
+
+	function := p.pick(rt)
+	upstream, ok := p.upstreams[function]
+	if !ok {
+		http.Error(w, fmt.Sprintf("function %s isn't running", function), http.StatusBadGateway)
+		return function
+	}
+
+	if rt.Rewrite != "" {
+		r = r.Clone(r.Context())
+		r.URL.Path = rt.rewrite(r.URL.Path)
+		r.URL.RawPath = ""
+	}
+	upstream.ServeHTTP(w, r)
+	return function
+}
+
+// pick the function of a release by weight, only the ones running count
+func (p *Proxy) pick(rt *Route) string {
+	total := 0
+	for _, s := range rt.Splits {
+		if _, ok := p.upstreams[s.Function]; ok {
+			total += s.Weight
+		}
+	}
+	if total == 0 {
+		return rt.Function
+	}
+
+	n := rand.Intn(total)
+	for _, s := range rt.Splits {
+		if _, ok := p.upstreams[s.Function]; !ok {
+			continue
+		}
+		if n < s.Weight {
+			return s.Function
+		}
+		n -= s.Weight
+	}
+	return rt.Function
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
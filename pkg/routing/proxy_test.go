@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/contextcloud/ccb/pkg/parser"
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+func testTable() []*Route {
+	api := &parser.Function{Key: "api"}
+	api.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/api"},
+		{Name: "old", FQDN: "demo.com", Prefix: "/api/old", Redirect: "https://www.demo.com/new"},
+	}
+	profile := &parser.Function{Key: "profile"}
+	profile.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/api/profile"},
+	}
+
+	routes := []*parser.Route{{Key: "demo"}}
+	routes[0].FQDN = "demo.com"
+	routes[0].Routes = []manifests.RouteInclude{
+		{Name: "api", Prefix: "/api"},
+		{Name: "assets", Namespace: "other", Prefix: "/assets"},
+	}
+
+	return BuildTable(routes, []*parser.Function{api, profile}, nil)
+}
+
+func Test_Match(t *testing.T) {
+	table := testTable()
+
+	tests := map[string]string{
+		"/api/profile/me": "profile",
+		"/api/users":      "api",
+		"/api":            "api",
+	}
+	for path, expected := range tests {
+		rt := Match(table, "localhost:8000", path)
+		if rt == nil || rt.Function != expected {
+			t.Errorf("Invalid match for %s: %v", path, rt)
+		}
+	}
+
+	if rt := Match(table, "localhost", "/other"); rt != nil {
+		t.Errorf("Expected no match: %v", rt)
+	}
+}
+
+func Test_Proxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "profile "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	var buf bytes.Buffer
+	proxy, err := NewProxy(print.NewLog(&buf), testTable(), map[string]string{
+		"profile": upstream.URL,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/api/profile/me", http.StatusOK, "profile /api/profile/me"},
+		{"/api/old/page", http.StatusMovedPermanently, ""},
+		{"/api/users", http.StatusBadGateway, ""},
+		{"/assets/logo.png", http.StatusBadGateway, ""},
+		{"/missing", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8000"+test.path, nil))
+
+		if rec.Code != test.status {
+			t.Errorf("Invalid status for %s: %d", test.path, rec.Code)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("Invalid body for %s: %s", test.path, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:8000/api/old", nil))
+	if loc := rec.Header().Get("Location"); loc != "https://www.demo.com/new" {
+		t.Errorf("Invalid redirect: %s", loc)
+	}
+}
+
+func Test_ProxyConditions(t *testing.T) {
+	api := &parser.Function{Key: "api"}
+	api.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/items"},
+		{Name: "api", FQDN: "demo.com", Prefix: "/orders", Methods: []string{"GET"}},
+		{Name: "api", FQDN: "demo.com", Prefix: "/static", Rewrite: "/"},
+	}
+	beta := &parser.Function{Key: "beta"}
+	beta.Routes = []manifests.FunctionRoute{
+		{Name: "api", FQDN: "demo.com", Prefix: "/items", Headers: []manifests.RouteHeader{{Name: "X-Tenant", Value: "beta"}}},
+		{Name: "api", FQDN: "demo.com", Prefix: "/reports", Headers: []manifests.RouteHeader{{Name: "X-Tenant", Value: "beta"}}},
+	}
+	table := BuildTable(nil, []*parser.Function{api, beta}, nil)
+
+	upstreams := make(map[string]string)
+	for _, name := range []string{"api", "beta"} {
+		name := name
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name+" "+r.URL.Path)
+		}))
+		defer upstream.Close()
+		upstreams[name] = upstream.URL
+	}
+
+	var buf bytes.Buffer
+	proxy, err := NewProxy(print.NewLog(&buf), table, upstreams)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		tenant string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/items/1", "", http.StatusOK, "api /items/1"},
+		{http.MethodGet, "/items/1", "beta", http.StatusOK, "beta /items/1"},
+		{http.MethodDelete, "/items/1", "", http.StatusOK, "api /items/1"},
+		{http.MethodGet, "/reports", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/reports", "beta", http.StatusOK, "beta /reports"},
+		{http.MethodDelete, "/orders/1", "", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/static/logo.png", "", http.StatusOK, "api /logo.png"},
+		{http.MethodGet, "/static", "", http.StatusOK, "api /"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://localhost:8000"+test.path, nil)
+		if test.tenant != "" {
+			req.Header.Set("X-Tenant", test.tenant)
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("Invalid status for %s %s: %d", test.method, test.path, rec.Code)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("Invalid body for %s %s: %s", test.method, test.path, rec.Body.String())
+		}
+	}
+}
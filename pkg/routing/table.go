@@ -0,0 +1,208 @@
+package routing
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/contextcloud/ccb/pkg/parser"
+)
+
+// Route is a single prefix of a host, it either passes to a function,
+// redirects or is served by another namespace. Splits are set when the
+// function is in a release.
+type Route struct {
+	Host      string
+	Prefix    string
+	Name      string
+	Function  string
+	Redirect  string
+	Namespace string
+
+	Methods []string
+	Headers []manifests.RouteHeader
+	Rewrite string
+	Splits  []manifests.ReleaseSplit
+}
+
+// BuildTable from the routes of the stack and its functions, the same paths
+// the VirtualServer and VirtualServerRoutes describe.
+func BuildTable(routes []*parser.Route, fns []*parser.Function, releases []*parser.Release) []*Route {
+	inRelease := make(map[string]*parser.Release)
+	for _, r := range releases {
+		for _, split := range r.Splits {
+			inRelease[split.Function] = r
+		}
+	}
+
+	var out []*Route
+	for _, fn := range fns {
+		for _, r := range fn.Routes {
+			rt := &Route{
+				Host:     r.FQDN,
+				Prefix:   r.Prefix,
+				Name:     r.Name,
+				Redirect: r.Redirect,
+				Methods:  r.Methods,
+				Headers:  r.Headers,
+				Rewrite:  r.Rewrite,
+			}
+			if rt.Redirect == "" {
+				rt.Function = fn.Key
+				if release, ok := inRelease[fn.Key]; ok {
+					rt.Splits = release.Splits
+				}
+			}
+			out = append(out, rt)
+		}
+	}
+
+	// includes of this namespace are covered by the function routes
+	for _, r := range routes {
+		for _, include := range r.Routes {
+			if include.Redirect == "" && include.Namespace == "" {
+				continue
+			}
+			out = append(out, &Route{
+				Host:      r.FQDN,
+				Prefix:    include.Prefix,
+				Name:      include.Name,
+				Redirect:  include.Redirect,
+				Namespace: include.Namespace,
+			})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		if len(out[i].Prefix) != len(out[j].Prefix) {
+			return len(out[i].Prefix) > len(out[j].Prefix)
+		}
+		return out[i].Prefix < out[j].Prefix
+	})
+	return out
+}
+
+// Match the longest prefix for a request. Hosts that aren't in the table,
+// like localhost, match the routes of every host.
+func Match(table []*Route, host string, path string) *Route {
+	if found := longest(table, host, path); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// MatchRequest is Match with the methods and headers of the routes, the
+// routes with them are tried before the one without. When none of them take
+// the request the route is nil and the status is what the router answers.
+func MatchRequest(table []*Route, r *http.Request) (*Route, int) {
+	found := longest(table, r.Host, r.URL.Path)
+	if len(found) == 0 {
+		return nil, http.StatusNotFound
+	}
+
+	var fallback *Route
+	status := http.StatusMethodNotAllowed
+	for _, rt := range found {
+		if len(rt.Methods) == 0 && len(rt.Headers) == 0 {
+			if fallback == nil {
+				fallback = rt
+			}
+			continue
+		}
+		if rt.accepts(r) {
+			return rt, http.StatusOK
+		}
+		if len(rt.Headers) > 0 {
+			status = http.StatusNotFound
+		}
+	}
+	if fallback != nil {
+		return fallback, http.StatusOK
+	}
+	return nil, status
+}
+
+// longest are the routes with the longest prefix of the path, in table order
+func longest(table []*Route, host string, path string) []*Route {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	known := false
+	for _, rt := range table {
+		if rt.Host == host {
+			known = true
+			break
+		}
+	}
+
+	var out []*Route
+	for _, rt := range table {
+		if known && rt.Host != host {
+			continue
+		}
+		if !strings.HasPrefix(path, rt.Prefix) {
+			continue
+		}
+		switch {
+		case len(out) == 0 || len(rt.Prefix) > len(out[0].Prefix):
+			out = []*Route{rt}
+		case len(rt.Prefix) == len(out[0].Prefix):
+			out = append(out, rt)
+		}
+	}
+	return out
+}
+
+// accepts the request when it has one of the methods and all of the headers
+func (rt *Route) accepts(r *http.Request) bool {
+	if len(rt.Methods) > 0 {
+		found := false
+		for _, m := range rt.Methods {
+			found = found || m == r.Method
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, h := range rt.Headers {
+		value := r.Header.Get(h.Name)
+		switch h.Operator {
+		case manifests.HeaderNotEquals:
+			if value == h.Value {
+				return false
+			}
+		case manifests.HeaderRegex:
+			// the parser checked it
+			if ok, _ := regexp.MatchString("^(?:"+h.Value+")$", value); !ok {
+				return false
+			}
+		default:
+			if value != h.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rewrite replaces the prefix of the path like the routers do
+func (rt *Route) rewrite(path string) string {
+	if rt.Rewrite == "" {
+		return path
+	}
+	rest := strings.TrimPrefix(path, rt.Prefix)
+	if strings.HasSuffix(rt.Rewrite, "/") {
+		rest = strings.TrimPrefix(rest, "/")
+	} else if rest != "" && !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	return rt.Rewrite + rest
+}
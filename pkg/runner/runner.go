@@ -0,0 +1,464 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/contextcloud/ccb/pkg/builder"
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// Ports a function listens on, the same as the deployment
+const (
+	PortHTTP    = 8080
+	PortMetrics = 8081
+	PortHealth  = 8082
+)
+
+// Labels on the containers ccb runs
+const (
+	LabelManaged  = "dev.contextcloud.ccb.managed"
+	LabelFunction = builder.LabelFunction
+	LabelStack    = "dev.contextcloud.ccb.stack"
+)
+
+// secretsPath is where secrets are mounted, the same as the deployment
+const secretsPath = "/var/secrets"
+
+const defaultTimeout = time.Minute
+
+// ErrNotReady when a function doesn't pass its probes in time
+var ErrNotReady = errors.New("function isn't ready")
+
+// Options to run a function
+type Options struct {
+	Name    string
+	Image   string
+	Env     map[string]string
+	Secrets map[string]string
+	Labels  map[string]string
+	Network string
+	// Aliases of the container on the network
+	Aliases []string
+
+	// Host ports for http, metrics and health, zero picks a free port
+	HTTPPort    int
+	MetricsPort int
+	HealthPort  int
+}
+
+// Container is a running function
+type Container struct {
+	ID          string
+	Name        string
+	HTTPPort    int
+	MetricsPort int
+	HealthPort  int
+
+	secretsDir string
+}
+
+// Runner runs functions in containers
+type Runner interface {
+	ImageExists(ctx context.Context, image string) (bool, error)
+	EnsureNetwork(ctx context.Context, name string) error
+	Start(ctx context.Context, opts *Options) (*Container, error)
+	Wait(ctx context.Context, c *Container, timeout time.Duration) error
+	Logs(ctx context.Context, c *Container, w io.Writer, follow bool) error
+	Stop(ctx context.Context, c *Container) error
+	Find(ctx context.Context, labels map[string]string) ([]*Container, error)
+}
+
+// dockerClient is the part of the docker api functions are run with
+type dockerClient interface {
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	NetworkInspect(ctx context.Context, network string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+	NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, id string, options container.StartOptions) error
+	ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error)
+	ContainerLogs(ctx context.Context, id string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+}
+
+type runner struct {
+	log print.Log
+	cli dockerClient
+}
+
+// NewRunner with the docker client from the environment
+func NewRunner(log print.Log) (Runner, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	return &runner{
+		log: log,
+		cli: cli,
+	}, nil
+}
+
+// ImageExists checks the image has been built or pulled
+func (r *runner) ImageExists(ctx context.Context, image string) (bool, error) {
+	_, _, err := r.cli.ImageInspectWithRaw(ctx, image)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EnsureNetwork creates a bridge network for functions to reach each other
+func (r *runner) EnsureNetwork(ctx context.Context, name string) error {
+	_, err := r.cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	_, err = r.cli.NetworkCreate(ctx, name, types.NetworkCreate{
+		Driver: "bridge",
+		Labels: map[string]string{LabelManaged: "true"},
+	})
+	return err
+}
+
+// ContainerName for a function
+func ContainerName(name string) string {
+	return "ccb-" + name
+}
+
+// Start a function, a container left over from a previous run is replaced
+func (r *runner) Start(ctx context.Context, opts *Options) (*Container, error) {
+	name := ContainerName(opts.Name)
+	if err := r.cli.ContainerRemove(ctx, name, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+
+	// secrets are both env and files, like envFrom and the projected volume
+	env := make(map[string]string)
+	for k, v := range opts.Secrets {
+		env[k] = v
+	}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+
+	labels := map[string]string{
+		LabelManaged:  "true",
+		LabelFunction: opts.Name,
+	}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+
+	ports := map[int]int{
+		PortHTTP:    opts.HTTPPort,
+		PortMetrics: opts.MetricsPort,
+		PortHealth:  opts.HealthPort,
+	}
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for port, host := range ports {
+		p := nat.Port(fmt.Sprintf("%d/tcp", port))
+		exposed[p] = struct{}{}
+
+		hostPort := ""
+		if host > 0 {
+			hostPort = strconv.Itoa(host)
+		}
+		bindings[p] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: hostPort}}
+	}
+
+	c := &Container{
+		Name: name,
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings: bindings,
+		NetworkMode:  container.NetworkMode(opts.Network),
+		Mounts: []mount.Mount{
+			{Type: mount.TypeTmpfs, Target: "/tmp"},
+		},
+	}
+	if len(opts.Secrets) > 0 {
+		dir, err := writeSecrets(opts.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		c.secretsDir = dir
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   filepath.Join(dir, filepath.Base(secretsPath)),
+			Target:   secretsPath,
+			ReadOnly: true,
+		})
+	}
+
+	config := &container.Config{
+		Image:        opts.Image,
+		Env:          envList(env),
+		Labels:       labels,
+		ExposedPorts: exposed,
+	}
+
+	var networking *network.NetworkingConfig
+	if opts.Network != "" && len(opts.Aliases) > 0 {
+		networking = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				opts.Network: {Aliases: opts.Aliases},
+			},
+		}
+	}
+
+	created, err := r.cli.ContainerCreate(ctx, config, hostConfig, networking, nil, name)
+	if err != nil {
+		r.cleanup(c)
+		return nil, err
+	}
+	c.ID = created.ID
+
+	if err := r.cli.ContainerStart(ctx, c.ID, container.StartOptions{}); err != nil {
+		_ = r.Stop(ctx, c)
+		return nil, err
+	}
+
+	// find the ports docker picked
+	inspect, err := r.cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		_ = r.Stop(ctx, c)
+		return nil, err
+	}
+	if inspect.NetworkSettings != nil {
+		c.HTTPPort = hostPort(inspect.NetworkSettings.Ports, PortHTTP)
+		c.MetricsPort = hostPort(inspect.NetworkSettings.Ports, PortMetrics)
+		c.HealthPort = hostPort(inspect.NetworkSettings.Ports, PortHealth)
+	}
+
+	r.log.Printf("%s: Started %s\n", opts.Name, name)
+	return c, nil
+}
+
+// Wait for the live and ready probes to pass
+func (r *runner) Wait(ctx context.Context, c *Container, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", c.HealthPort)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		inspect, err := r.cli.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		if inspect.State != nil && !inspect.State.Running {
+			return fmt.Errorf("%s exited with code %d: %s", c.Name, inspect.State.ExitCode, r.tail(c))
+		}
+
+		if probe(ctx, base+"/live") && probe(ctx, base+"/ready") {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %s: %s", ErrNotReady, timeout, r.tail(c))
+		case <-ticker.C:
+		}
+	}
+}
+
+// Logs of the function, follow streams them until ctx is done
+func (r *runner) Logs(ctx context.Context, c *Container, w io.Writer, follow bool) error {
+	out, err := r.cli.ContainerLogs(ctx, c.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = stdcopy.StdCopy(w, w, out)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// Stop and remove the function
+func (r *runner) Stop(ctx context.Context, c *Container) error {
+	defer r.cleanup(c)
+
+	if c.ID == "" {
+		return nil
+	}
+	err := r.cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true})
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Find the running functions with the labels, like the ones of a stack
+func (r *runner) Find(ctx context.Context, labels map[string]string) ([]*Container, error) {
+	args := filters.NewArgs(filters.Arg("label", LabelManaged+"=true"))
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+
+	list, err := r.cli.ContainerList(ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Container
+	for _, item := range list {
+		c := &Container{ID: item.ID}
+		if len(item.Names) > 0 {
+			c.Name = strings.TrimPrefix(item.Names[0], "/")
+		}
+		for _, p := range item.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			switch int(p.PrivatePort) {
+			case PortHTTP:
+				c.HTTPPort = int(p.PublicPort)
+			case PortMetrics:
+				c.MetricsPort = int(p.PublicPort)
+			case PortHealth:
+				c.HealthPort = int(p.PublicPort)
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func (r *runner) cleanup(c *Container) {
+	if c.secretsDir != "" {
+		os.RemoveAll(c.secretsDir)
+	}
+}
+
+// tail is the end of the logs to explain why a function didn't start
+func (r *runner) tail(c *Container) string {
+	out, err := r.cli.ContainerLogs(context.Background(), c.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       "20",
+	})
+	if err != nil {
+		return err.Error()
+	}
+	defer out.Close()
+
+	var sb strings.Builder
+	_, _ = stdcopy.StdCopy(&sb, &sb, out)
+	return strings.TrimSpace(sb.String())
+}
+
+func probe(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func hostPort(ports nat.PortMap, port int) int {
+	bindings := ports[nat.Port(fmt.Sprintf("%d/tcp", port))]
+	for _, b := range bindings {
+		if p, err := strconv.Atoi(b.HostPort); err == nil {
+			return p
+		}
+	}
+	return 0
+}
+
+func envList(env map[string]string) []string {
+	out := make([]string, 0, len(env))
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// writeSecrets to files any user in the container can read, like the
+// defaultMode of the deployment. They're kept in a directory under a private
+// parent, MkdirTemp makes it 0700, so only the user can reach them on the host.
+// The parent is returned, the files are in its secrets directory.
+func writeSecrets(secrets map[string]string) (string, error) {
+	dir, err := os.MkdirTemp("", "ccb-secrets-")
+	if err != nil {
+		return "", err
+	}
+
+	files := filepath.Join(dir, filepath.Base(secretsPath))
+	if err := writeFiles(files, secrets); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// writeFiles into dir, the modes are set explicitly so the umask can't hide
+// them from the container user
+func writeFiles(dir string, files map[string]string) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+	for k, v := range files {
+		name := filepath.Join(dir, filepath.Base(k))
+		if err := os.WriteFile(name, []byte(v), 0644); err != nil {
+			return err
+		}
+		if err := os.Chmod(name, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// fakeDocker keeps the containers it's asked to create
+type fakeDocker struct {
+	images     map[string]bool
+	config     *container.Config
+	hostConfig *container.HostConfig
+	removed    []string
+	running    bool
+	exitCode   int
+	healthPort int
+	logs       string
+}
+
+func (f *fakeDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	if !f.images[image] {
+		return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image"))
+	}
+	return types.ImageInspect{ID: image}, nil, nil
+}
+
+func (f *fakeDocker) NetworkInspect(ctx context.Context, network string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	return types.NetworkResource{}, errdefs.NotFound(errors.New("no such network"))
+}
+
+func (f *fakeDocker) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	return types.NetworkCreateResponse{ID: name}, nil
+}
+
+func (f *fakeDocker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error) {
+	f.config = config
+	f.hostConfig = hostConfig
+	return container.CreateResponse{ID: "c1"}, nil
+}
+
+func (f *fakeDocker) ContainerStart(ctx context.Context, id string, options container.StartOptions) error {
+	f.running = true
+	return nil
+}
+
+func (f *fakeDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	ports := nat.PortMap{}
+	for _, port := range []int{PortHTTP, PortMetrics, PortHealth} {
+		host := 30000 + port
+		if port == PortHealth && f.healthPort > 0 {
+			host = f.healthPort
+		}
+		ports[nat.Port(strconv.Itoa(port)+"/tcp")] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: strconv.Itoa(host)}}
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    id,
+			State: &types.ContainerState{Running: f.running, ExitCode: f.exitCode},
+		},
+		NetworkSettings: &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{Ports: ports}},
+	}, nil
+}
+
+func (f *fakeDocker) ContainerLogs(ctx context.Context, id string, options container.LogsOptions) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	_, _ = stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(f.logs))
+	return io.NopCloser(&buf), nil
+}
+
+func (f *fakeDocker) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func (f *fakeDocker) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return []types.Container{{
+		ID:    "c1",
+		Names: []string{"/ccb-api"},
+		Ports: []types.Port{{PrivatePort: PortHTTP, PublicPort: 38080}, {PrivatePort: PortHealth, PublicPort: 38082}},
+	}}, nil
+}
+
+func newFakeRunner(cli *fakeDocker) *runner {
+	return &runner{log: print.NewLog(io.Discard), cli: cli}
+}
+
+func Test_ImageExists(t *testing.T) {
+	r := newFakeRunner(&fakeDocker{images: map[string]bool{"api:latest": true}})
+
+	for image, expected := range map[string]bool{"api:latest": true, "profile:latest": false} {
+		exists, err := r.ImageExists(context.Background(), image)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if exists != expected {
+			t.Errorf("Invalid exists for %s: %v", image, exists)
+		}
+	}
+}
+
+func Test_Start(t *testing.T) {
+	cli := &fakeDocker{}
+	r := newFakeRunner(cli)
+
+	c, err := r.Start(context.Background(), &Options{
+		Name:    "api",
+		Image:   "api:latest",
+		Env:     map[string]string{"MODE": "dev", "TOKEN": "env"},
+		Secrets: map[string]string{"TOKEN": "secret", "PASSWORD": "hunter2"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// env wins over secrets like it does in the deployment
+	env := strings.Join(cli.config.Env, " ")
+	if env != "MODE=dev PASSWORD=hunter2 TOKEN=env" {
+		t.Errorf("Invalid env: %s", env)
+	}
+	if cli.config.Labels[LabelFunction] != "api" || cli.config.Labels[LabelManaged] != "true" {
+		t.Errorf("Invalid labels: %v", cli.config.Labels)
+	}
+	for port, bindings := range cli.hostConfig.PortBindings {
+		if len(bindings) != 1 || bindings[0].HostIP != "127.0.0.1" {
+			t.Errorf("Invalid bindings for %s: %v", port, bindings)
+		}
+	}
+	if c.HTTPPort != 30000+PortHTTP || c.HealthPort != 30000+PortHealth {
+		t.Errorf("Invalid ports: %+v", c)
+	}
+
+	// the secrets can be read by any container user, like the deployment,
+	// but only the user can reach them on the host
+	var secrets string
+	for _, m := range cli.hostConfig.Mounts {
+		if m.Target == secretsPath && m.ReadOnly {
+			secrets = m.Source
+		}
+	}
+	if secrets == "" {
+		t.Errorf("Secrets not mounted: %v", cli.hostConfig.Mounts)
+		return
+	}
+	modes := map[string]os.FileMode{
+		filepath.Dir(secrets):              0700,
+		secrets:                            0755,
+		filepath.Join(secrets, "PASSWORD"): 0644,
+	}
+	for p, expected := range modes {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("Invalid mode for %s: %s", p, info.Mode())
+		}
+	}
+
+	if err := r.Stop(context.Background(), c); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(cli.removed) != 2 || cli.removed[1] != "c1" {
+		t.Errorf("Container not removed: %v", cli.removed)
+	}
+	if _, err := os.Stat(filepath.Dir(secrets)); !os.IsNotExist(err) {
+		t.Errorf("Secrets left behind: %v", err)
+	}
+}
+
+func Test_Wait(t *testing.T) {
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer health.Close()
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(health.URL, "http://"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	healthPort, _ := strconv.Atoi(port)
+
+	cli := &fakeDocker{healthPort: healthPort, running: true}
+	r := newFakeRunner(cli)
+	c := &Container{ID: "c1", Name: "ccb-api", HealthPort: healthPort}
+
+	if err := r.Wait(context.Background(), c, 5*time.Second); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the logs say why it exited
+	cli.running = false
+	cli.exitCode = 2
+	cli.logs = "missing DATABASE_URL\n"
+	err = r.Wait(context.Background(), c, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "exited with code 2: missing DATABASE_URL") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func Test_Logs(t *testing.T) {
+	r := newFakeRunner(&fakeDocker{logs: "listening on :8080\n"})
+
+	var buf bytes.Buffer
+	if err := r.Logs(context.Background(), &Container{ID: "c1"}, &buf, false); err != nil {
+		t.Error(err)
+		return
+	}
+	if buf.String() != "listening on :8080\n" {
+		t.Errorf("Invalid logs: %q", buf.String())
+	}
+}
+
+func Test_Find(t *testing.T) {
+	r := newFakeRunner(&fakeDocker{})
+
+	found, err := r.Find(context.Background(), map[string]string{LabelStack: "demo"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(found) != 1 || found[0].Name != "ccb-api" || found[0].HTTPPort != 38080 || found[0].HealthPort != 38082 {
+		t.Errorf("Invalid containers: %+v", found)
+	}
+}
@@ -0,0 +1,231 @@
+package templater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const cacheMetadataExt = ".yaml"
+
+// Cache of templates shared across projects, entries are keyed by the source
+// and the commit it was resolved to so they never change once written.
+type Cache struct {
+	Dir string
+}
+
+// CacheEntry describes a cached template
+type CacheEntry struct {
+	Key      string    `yaml:"-"`
+	Source   string    `yaml:"source"`
+	Resolved string    `yaml:"resolved"`
+	Hash     string    `yaml:"hash"`
+	Created  time.Time `yaml:"created"`
+	LastUsed time.Time `yaml:"last_used"`
+}
+
+// DefaultCacheDir is ccb/templates in the user cache dir, e.g. $XDG_CACHE_HOME
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ccb", templatesDir), nil
+}
+
+// NewCache in dir, or the default cache dir when it's empty
+func NewCache(dir string) (*Cache, error) {
+	if dir == "" {
+		d, err := DefaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = d
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// cacheable sources are pinned to a commit
+func cacheable(resolved string) bool {
+	return commitRegex.MatchString(resolved)
+}
+
+// CacheKey for a source at a resolved ref
+func CacheKey(source string, resolved string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + resolved))
+	return hex.EncodeToString(sum[:])
+}
+
+// Path of the template files for a key
+func (c *Cache) Path(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+func (c *Cache) metadataPath(key string) string {
+	return filepath.Join(c.Dir, key+cacheMetadataExt)
+}
+
+// Get an entry from the cache and mark it as used
+func (c *Cache) Get(source string, resolved string) (*CacheEntry, bool) {
+	key := CacheKey(source, resolved)
+	entry, err := c.read(key)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := os.Stat(c.Path(key)); err != nil {
+		return nil, false
+	}
+
+	entry.LastUsed = time.Now().UTC()
+	if err := c.write(entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Put a template into the cache, download fills the directory it's given.
+func (c *Cache) Put(source string, resolved string, download func(dst string) error) (*CacheEntry, error) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp(c.Dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// go-getter wants to create the destination itself
+	dst := filepath.Join(tmp, "template")
+	if err := download(dst); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashDir(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	// another fetch may have won the race, the content is the same
+	key := CacheKey(source, resolved)
+	if err := os.Rename(dst, c.Path(key)); err != nil {
+		if _, serr := os.Stat(c.Path(key)); serr != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	entry := &CacheEntry{
+		Key:      key,
+		Source:   source,
+		Resolved: resolved,
+		Hash:     hash,
+		Created:  now,
+		LastUsed: now,
+	}
+	return entry, c.write(entry)
+}
+
+// List the entries in the cache, most recently used first
+func (c *Cache) List() ([]*CacheEntry, error) {
+	files, err := os.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*CacheEntry
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), cacheMetadataExt) {
+			continue
+		}
+
+		entry, err := c.read(strings.TrimSuffix(f.Name(), cacheMetadataExt))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Remove an entry from the cache
+func (c *Cache) Remove(key string) error {
+	if err := os.RemoveAll(c.Path(key)); err != nil {
+		return err
+	}
+	if err := os.Remove(c.metadataPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Prune removes the entries not used since before, a zero time removes all
+func (c *Cache) Prune(before time.Time) ([]*CacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []*CacheEntry
+	for _, entry := range entries {
+		if !before.IsZero() && entry.LastUsed.After(before) {
+			continue
+		}
+		if err := c.Remove(entry.Key); err != nil {
+			return nil, err
+		}
+		pruned = append(pruned, entry)
+	}
+	return pruned, nil
+}
+
+func (c *Cache) read(key string) (*CacheEntry, error) {
+	out, err := os.ReadFile(c.metadataPath(key))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CacheEntry{}
+	if err := yaml.Unmarshal(out, entry); err != nil {
+		return nil, err
+	}
+	entry.Key = key
+	return entry, nil
+}
+
+func (c *Cache) write(entry *CacheEntry) error {
+	out, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// write then rename so concurrent readers never see half a file
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*"+cacheMetadataExt)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.metadataPath(entry.Key))
+}
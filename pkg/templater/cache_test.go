@@ -0,0 +1,114 @@
+package templater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+func Test_Cache(t *testing.T) {
+	repo := newGitRepo(t)
+	cacheDir := t.TempDir()
+
+	sources := map[string]manifests.TemplateSource{
+		"golang": {Source: "git::file://" + repo},
+	}
+
+	// two projects share a single cache entry
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		tmpl := NewTemplater(&Options{
+			WorkingDir: dir,
+			CacheDir:   cacheDir,
+			Sources:    sources,
+		})
+		tmpl.AddFunction("api", "golang@v1.0.0")
+
+		if _, err := tmpl.Download(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(path.Join(TemplatePath(dir, "golang@v1.0.0"), "Dockerfile")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cache, err := NewCache(cacheDir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entries, err := cache.List()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 1 {
+		t.Errorf("Invalid cache entries: %d", len(entries))
+		return
+	}
+
+	pruned, err := cache.Prune(time.Now().Add(-time.Hour))
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Invalid prune: %v %d", err, len(pruned))
+	}
+	pruned, err = cache.Prune(time.Time{})
+	if err != nil || len(pruned) != 1 {
+		t.Errorf("Invalid prune: %v %d", err, len(pruned))
+	}
+	if _, err := os.Stat(cache.Path(entries[0].Key)); !os.IsNotExist(err) {
+		t.Errorf("Expected entry to be removed: %v", err)
+	}
+}
+
+func Test_Offline(t *testing.T) {
+	repo := newGitRepo(t)
+	sources := map[string]manifests.TemplateSource{
+		"golang": {Source: "git::file://" + repo},
+	}
+
+	download := func(opts *Options) error {
+		opts.Sources = sources
+		tmpl := NewTemplater(opts)
+		tmpl.AddFunction("api", "golang@v1.0.0")
+		tmpl.AddFunction("worker", "golang@main")
+		_, err := tmpl.Download(context.Background())
+		return err
+	}
+
+	// nothing is cached or vendored
+	dir := t.TempDir()
+	err := download(&Options{WorkingDir: dir, CacheDir: t.TempDir(), Offline: true})
+	if !errors.Is(err, ErrOffline) || !strings.Contains(err.Error(), "golang@main, golang@v1.0.0") {
+		t.Errorf("Expected missing templates: %v", err)
+		return
+	}
+
+	// vendored templates work with an empty cache
+	if err := download(&Options{WorkingDir: dir, CacheDir: t.TempDir(), Vendor: true}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.RemoveAll(path.Join(dir, ".ccb", templatesDir)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := download(&Options{WorkingDir: dir, CacheDir: t.TempDir(), Offline: true}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := FindTemplate(dir, "golang@main"); err != nil {
+		t.Error(err)
+	}
+}
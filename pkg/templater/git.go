@@ -0,0 +1,146 @@
+package templater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+	"golang.org/x/mod/semver"
+)
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// gitRepository returns the repository url for a git source or false when
+// the source isn't fetched with git.
+func gitRepository(src string) (string, bool, error) {
+	detected, err := getter.Detect(src, ".", getter.Detectors)
+	if err != nil {
+		return "", false, err
+	}
+
+	forced, raw, ok := strings.Cut(detected, "::")
+	if !ok || forced != "git" {
+		return "", false, nil
+	}
+
+	repo, _ := getter.SourceDirSubdir(raw)
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", false, err
+	}
+
+	q := u.Query()
+	q.Del("ref")
+	q.Del("depth")
+	q.Del("sshkey")
+	u.RawQuery = q.Encode()
+
+	return u.String(), true, nil
+}
+
+// lsRemote lists the refs of a git source by name, false when the source
+// isn't fetched with git. The repository is returned without credentials.
+func lsRemote(ctx context.Context, src string, creds credentials, flags []string, patterns ...string) (map[string]string, string, bool, error) {
+	repo, ok, err := gitRepository(src)
+	if err != nil || !ok {
+		return nil, "", ok, err
+	}
+
+	// keep tokens out of errors
+	display := repo
+	if u, err := url.Parse(repo); err == nil {
+		display = u.Redacted()
+	}
+
+	args := append([]string{"ls-remote"}, flags...)
+	args = append(args, repo)
+	args = append(args, patterns...)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stderr = &stderr
+	cmd.Env = creds.environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, display, true, fmt.Errorf("git ls-remote %s: %w: %s", display, err, strings.TrimSpace(stderr.String()))
+	}
+
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	return refs, display, true, nil
+}
+
+// resolveRef resolves a branch or tag of a git source to a commit, other
+// sources can't be resolved and keep the ref as is.
+func resolveRef(ctx context.Context, src string, creds credentials, ref string) (string, error) {
+	if commitRegex.MatchString(ref) {
+		return ref, nil
+	}
+
+	target := ref
+	if target == "" {
+		target = "HEAD"
+	}
+
+	refs, display, ok, err := lsRemote(ctx, src, creds, nil, target, target+"^{}")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return ref, nil
+	}
+
+	// peeled annotated tags point at the commit rather than the tag object
+	candidates := []string{
+		"refs/tags/" + target + "^{}",
+		"refs/tags/" + target,
+		"refs/heads/" + target,
+		target,
+	}
+	for _, c := range candidates {
+		if sha, ok := refs[c]; ok {
+			return sha, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s in %s", ErrUnknownRef, target, display)
+}
+
+// listTags of a git source, newest semver first
+func listTags(ctx context.Context, src string, creds credentials) ([]string, error) {
+	refs, _, ok, err := lsRemote(ctx, src, creds, []string{"--tags", "--refs"})
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var tags []string
+	for ref := range refs {
+		tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		vi, vj := semver.IsValid(tags[i]), semver.IsValid(tags[j])
+		if vi && vj {
+			if c := semver.Compare(tags[i], tags[j]); c != 0 {
+				return c > 0
+			}
+		}
+		if vi != vj {
+			return vi
+		}
+		return tags[i] < tags[j]
+	})
+	return tags, nil
+}
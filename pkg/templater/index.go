@@ -0,0 +1,244 @@
+package templater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"gopkg.in/yaml.v2"
+)
+
+const indexDir = "index"
+
+// indexTTL is how long an index is used before the source is listed again
+const indexTTL = 24 * time.Hour
+
+// Index of the templates in a source
+type Index struct {
+	Source    string           `yaml:"source"`
+	Resolved  string           `yaml:"resolved,omitempty"`
+	Updated   time.Time        `yaml:"updated"`
+	Versions  []string         `yaml:"versions,omitempty"`
+	Templates []*IndexTemplate `yaml:"templates"`
+}
+
+// IndexTemplate is a template found in a source
+type IndexTemplate struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Language    string `yaml:"language,omitempty"`
+
+	// Fetched are the specs fetched into the working directory
+	Fetched []string `yaml:"-"`
+}
+
+// List the templates of every configured source, indexes are kept in the
+// cache and refreshed once they're stale.
+func (t *templater) List(ctx context.Context, refresh bool) ([]*Index, error) {
+	cache, err := NewCache(t.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	t.cache = cache
+
+	lock, err := LoadLock(t.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Index
+	for _, ts := range t.sourceList() {
+		idx, err := t.index(ctx, ts, refresh)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ts.Source, err)
+		}
+		t.markFetched(idx, lock)
+		out = append(out, idx)
+	}
+	return out, nil
+}
+
+// sourceList is every distinct source, the default first unless a catch all
+// glob replaces it
+func (t *templater) sourceList() []manifests.TemplateSource {
+	def := t.source("*")
+	seen := map[string]bool{
+		normalizeSource(def.Source): true,
+	}
+	var sources []manifests.TemplateSource
+	for _, ts := range t.Sources {
+		src := normalizeSource(ts.Source)
+		if src == "" || seen[src] {
+			continue
+		}
+		seen[src] = true
+		sources = append(sources, ts)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Source < sources[j].Source
+	})
+
+	return append([]manifests.TemplateSource{def}, sources...)
+}
+
+func (t *templater) index(ctx context.Context, ts manifests.TemplateSource, refresh bool) (*Index, error) {
+	src := normalizeSource(ts.Source)
+	filename := t.cache.indexPath(src)
+
+	existing, err := loadIndex(filename)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && (t.Offline || (!refresh && time.Since(existing.Updated) < indexTTL)) {
+		return existing, nil
+	}
+	if t.Offline {
+		return nil, ErrOffline
+	}
+
+	authSrc, creds, err := authenticate(src, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := resolveRef(ctx, authSrc, creds, "")
+	if err != nil {
+		return nil, redact(err, ts)
+	}
+
+	download := func(dst string) error {
+		if err := t.download(ctx, withQuery(authSrc, "ref", resolved), creds, dst); err != nil {
+			return fmt.Errorf("unable to download %s: %w", src, redact(err, ts))
+		}
+		return nil
+	}
+
+	var dir string
+	if cacheable(resolved) {
+		entry, ok := t.cache.Get(src, resolved)
+		if !ok {
+			if entry, err = t.cache.Put(src, resolved, download); err != nil {
+				return nil, err
+			}
+		}
+		dir = t.cache.Path(entry.Key)
+	} else {
+		tmp, err := os.MkdirTemp("", "ccb-index-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+
+		dir = filepath.Join(tmp, "source")
+		if err := download(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	templates, err := scanTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := listTags(ctx, authSrc, creds)
+	if err != nil {
+		return nil, redact(err, ts)
+	}
+
+	idx := &Index{
+		Source:    src,
+		Resolved:  resolved,
+		Updated:   time.Now().UTC(),
+		Versions:  versions,
+		Templates: templates,
+	}
+	return idx, saveIndex(filename, idx)
+}
+
+// markFetched records which specs of each template are in the working directory
+func (t *templater) markFetched(idx *Index, lock *Lock) {
+	for _, tmpl := range idx.Templates {
+		src := withSubdir(idx.Source, tmpl.Name)
+		for spec, entry := range lock.Templates {
+			if name, _ := ParseTemplate(spec); name != tmpl.Name || entry.Source != src {
+				continue
+			}
+			if _, err := os.Stat(TemplatePath(t.WorkingDir, spec)); err == nil {
+				tmpl.Fetched = append(tmpl.Fetched, spec)
+			}
+		}
+		sort.Strings(tmpl.Fetched)
+	}
+}
+
+// scanTemplates finds the directories that look like templates
+func scanTemplates(dir string) ([]*IndexTemplate, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*IndexTemplate
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		tdir := filepath.Join(dir, f.Name())
+		meta, err := LoadMetadata(tdir)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			if _, err := os.Stat(filepath.Join(tdir, "Dockerfile")); err != nil {
+				continue
+			}
+			meta = &Metadata{}
+		}
+
+		out = append(out, &IndexTemplate{
+			Name:        f.Name(),
+			Description: meta.Description,
+			Language:    meta.Language,
+		})
+	}
+	return out, nil
+}
+
+func (c *Cache) indexPath(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(c.Dir, indexDir, hex.EncodeToString(sum[:])+".yaml")
+}
+
+func loadIndex(filename string) (*Index, error) {
+	out, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{}
+	if err := yaml.Unmarshal(out, idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return idx, nil
+}
+
+func saveIndex(filename string, idx *Index) error {
+	out, err := yaml.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, out, 0644)
+}
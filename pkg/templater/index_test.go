@@ -0,0 +1,69 @@
+package templater
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+func Test_List(t *testing.T) {
+	repo := newGitRepo(t)
+	dir := t.TempDir()
+
+	opts := &Options{
+		WorkingDir: dir,
+		CacheDir:   t.TempDir(),
+		Sources: map[string]manifests.TemplateSource{
+			"*": {Source: "git::file://" + repo},
+		},
+	}
+
+	tmpl := NewTemplater(opts)
+	tmpl.AddFunction("api", "golang@v1.0.0")
+	if _, err := tmpl.Download(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	indexes, err := NewTemplater(opts).List(context.Background(), false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(indexes) != 1 {
+		t.Errorf("Invalid indexes: %d", len(indexes))
+		return
+	}
+
+	idx := indexes[0]
+	if len(idx.Versions) != 1 || idx.Versions[0] != "v1.0.0" {
+		t.Errorf("Invalid versions: %v", idx.Versions)
+	}
+	if len(idx.Templates) != 1 || idx.Templates[0].Name != "golang" {
+		t.Errorf("Invalid templates: %v", idx.Templates)
+		return
+	}
+	if fetched := idx.Templates[0].Fetched; len(fetched) != 1 || fetched[0] != "golang@v1.0.0" {
+		t.Errorf("Invalid fetched: %v", fetched)
+	}
+
+	// the cache has the files of the source without its clone
+	err = filepath.WalkDir(opts.CacheDir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Name() == ".git" {
+			t.Errorf("Clone left in the cache: %s", p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the index is reused offline
+	opts.Offline = true
+	if _, err := NewTemplater(opts).List(context.Background(), false); err != nil {
+		t.Error(err)
+	}
+}
@@ -78,3 +78,14 @@ func copyDir(ctx context.Context, dst string, src string, ignoreDot bool) error
 
 	return filepath.Walk(src, walkFn)
 }
+
+// replaceDir replaces dst with a copy of src
+func replaceDir(ctx context.Context, dst string, src string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return copyDir(ctx, dst, src, false)
+}
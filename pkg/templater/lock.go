@@ -0,0 +1,140 @@
+package templater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const lockFile = "templates.lock"
+
+// Lock pins every fetched template to a resolved ref and content hash
+type Lock struct {
+	Templates map[string]*LockEntry `yaml:"templates"`
+
+	mu sync.Mutex
+}
+
+// LockEntry for a single template
+type LockEntry struct {
+	Source   string `yaml:"source"`
+	Ref      string `yaml:"ref,omitempty"`
+	Resolved string `yaml:"resolved,omitempty"`
+	Hash     string `yaml:"hash"`
+}
+
+// LockPath is where the lock file lives for a working directory
+func LockPath(workingDir string) string {
+	return path.Join(workingDir, ".ccb", lockFile)
+}
+
+// LoadLock from the working directory, a missing file is an empty lock
+func LoadLock(workingDir string) (*Lock, error) {
+	lock := &Lock{
+		Templates: make(map[string]*LockEntry),
+	}
+
+	out, err := os.ReadFile(LockPath(workingDir))
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(out, lock); err != nil {
+		return nil, err
+	}
+	if lock.Templates == nil {
+		lock.Templates = make(map[string]*LockEntry)
+	}
+	return lock, nil
+}
+
+// Get the entry for a template
+func (l *Lock) Get(template string) (*LockEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.Templates[template]
+	return entry, ok
+}
+
+// Set the entry for a template
+func (l *Lock) Set(template string, entry *LockEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Templates[template] = entry
+}
+
+// Save the lock into the working directory
+func (l *Lock) Save(workingDir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	filename := LockPath(workingDir)
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, out, 0644)
+}
+
+// hashDir hashes the relative path, mode and content of every file in dir.
+func hashDir(dir string) (string, error) {
+	h := sha256.New()
+
+	walkFn := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode().Perm()&0111)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(h, link)
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(h, f)
+		return err
+	}
+
+	if err := filepath.Walk(dir, walkFn); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
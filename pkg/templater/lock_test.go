@@ -0,0 +1,114 @@
+package templater
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// newGitRepo creates a git repository with a golang template tagged v1.0.0.
+func newGitRepo(t *testing.T) string {
+	dir := t.TempDir()
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=ccb", "-c", "user.email=ccb@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	if err := os.MkdirAll(path.Join(dir, "golang"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "golang", "Dockerfile"), []byte("FROM golang:1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	git("init", "-q", "-b", "main")
+	git("add", "-A")
+	git("commit", "-q", "-m", "golang")
+	git("tag", "-a", "v1.0.0", "-m", "v1.0.0")
+
+	return dir
+}
+
+func Test_HashDir(t *testing.T) {
+	first, err := hashDir("./example/assets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := hashDir("./example/assets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if first != second || !strings.HasPrefix(first, "sha256:") {
+		t.Errorf("Invalid hash: %s %s", first, second)
+	}
+}
+
+func Test_Lock(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := LoadLock(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lock.Set("golang@v1.0.0", &LockEntry{
+		Source:   "github.com/contextcloud/templates//golang",
+		Ref:      "v1.0.0",
+		Resolved: "0123456789abcdef0123456789abcdef01234567",
+		Hash:     "sha256:abc",
+	})
+	if err := lock.Save(dir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	loaded, err := LoadLock(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entry, ok := loaded.Get("golang@v1.0.0")
+	if !ok || entry.Resolved != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("Invalid entry: %v", entry)
+	}
+}
+
+func Test_ResolveRef(t *testing.T) {
+	repo := newGitRepo(t)
+
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repo
+	head, err := cmd.Output()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	src := "git::file://" + repo + "//golang"
+	for _, ref := range []string{"", "main", "v1.0.0"} {
+		resolved, err := resolveRef(context.Background(), src, credentials{}, ref)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if resolved != strings.TrimSpace(string(head)) {
+			t.Errorf("Invalid resolved ref for %q: %s", ref, resolved)
+		}
+	}
+
+	if _, err := resolveRef(context.Background(), src, credentials{}, "v9.9.9"); err == nil {
+		t.Error("Expected unknown ref")
+	}
+}
@@ -0,0 +1,162 @@
+package templater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MetadataFile describes a template, it lives in the root of the template
+const MetadataFile = "template.yml"
+
+// Metadata of a template
+type Metadata struct {
+	Name          string      `yaml:"name"`
+	Description   string      `yaml:"description,omitempty"`
+	Language      string      `yaml:"language,omitempty"`
+	BuildArgs     []*BuildArg `yaml:"build_args,omitempty"`
+	RequiredFiles []string    `yaml:"required_files,omitempty"`
+	Starter       string      `yaml:"starter,omitempty"`
+}
+
+// BuildArg the template supports
+type BuildArg struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Pattern     string   `yaml:"pattern,omitempty"`
+	Values      []string `yaml:"values,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// MetadataError lists why a function doesn't fit its template
+type MetadataError struct {
+	Template string
+	Problems []string
+}
+
+func (e *MetadataError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "function doesn't match template %s", e.Template)
+	for _, p := range e.Problems {
+		fmt.Fprintf(&sb, "\n  %s", p)
+	}
+	return sb.String()
+}
+
+// LoadMetadata from a template directory, templates without one return nil
+func LoadMetadata(dir string) (*Metadata, error) {
+	filename := filepath.Join(dir, MetadataFile)
+	out, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Metadata
+	if err := yaml.UnmarshalStrict(out, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	// the starter is copied into new functions, it can't come from outside
+	if m.Starter != "" && !filepath.IsLocal(m.Starter) {
+		return nil, fmt.Errorf("%s: starter %s must be a directory in the template", filename, m.Starter)
+	}
+
+	seen := make(map[string]bool)
+	for _, arg := range m.BuildArgs {
+		if arg.Name == "" {
+			return nil, fmt.Errorf("%s: build arg without a name", filename)
+		}
+		if seen[arg.Name] {
+			return nil, fmt.Errorf("%s: build arg %s declared twice", filename, arg.Name)
+		}
+		seen[arg.Name] = true
+
+		if arg.Pattern != "" {
+			r, err := regexp.Compile("^(?:" + arg.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s: build arg %s: %w", filename, arg.Name, err)
+			}
+			arg.pattern = r
+		}
+	}
+
+	return &m, nil
+}
+
+// Apply the defaults to the build args, set args win
+func (m *Metadata) Apply(args map[string]*string) map[string]*string {
+	out := make(map[string]*string)
+	for _, arg := range m.BuildArgs {
+		if arg.Default != "" {
+			v := arg.Default
+			out[arg.Name] = &v
+		}
+	}
+	for k, v := range args {
+		if v != nil {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Validate a function's files and build args against the template. Args the
+// template doesn't declare are allowed since global build args are shared.
+func (m *Metadata) Validate(template string, filesDir string, args map[string]*string) error {
+	var problems []string
+
+	args = m.Apply(args)
+	for _, arg := range m.BuildArgs {
+		v, ok := args[arg.Name]
+		if !ok || *v == "" {
+			if arg.Required {
+				problems = append(problems, fmt.Sprintf("build arg %s is required", arg.Name))
+			}
+			continue
+		}
+
+		if arg.pattern != nil && !arg.pattern.MatchString(*v) {
+			problems = append(problems, fmt.Sprintf("build arg %s=%s doesn't match %s", arg.Name, *v, arg.Pattern))
+		}
+		if len(arg.Values) > 0 && !contains(arg.Values, *v) {
+			problems = append(problems, fmt.Sprintf("build arg %s=%s isn't one of %s", arg.Name, *v, strings.Join(arg.Values, ", ")))
+		}
+	}
+
+	for _, file := range m.RequiredFiles {
+		matches, err := filepath.Glob(filepath.Join(filesDir, file))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			problems = append(problems, fmt.Sprintf("missing required file %s", file))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &MetadataError{
+			Template: template,
+			Problems: problems,
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
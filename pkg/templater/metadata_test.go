@@ -0,0 +1,163 @@
+package templater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+const testMetadata = `name: golang
+description: Go function
+language: go
+build_args:
+  - name: GO_VERSION
+    default: "1.21"
+    pattern: '1\.[0-9]+'
+  - name: CGO_ENABLED
+    values: ["0", "1"]
+  - name: MODULE
+    required: true
+required_files:
+  - go.mod
+`
+
+func Test_Metadata(t *testing.T) {
+	tdir := t.TempDir()
+	if err := os.WriteFile(path.Join(tdir, MetadataFile), []byte(testMetadata), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	meta, err := LoadMetadata(tdir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fdir := t.TempDir()
+	cgo := "2"
+	err = meta.Validate("golang", fdir, map[string]*string{"CGO_ENABLED": &cgo})
+
+	var merr *MetadataError
+	if !errors.As(err, &merr) || len(merr.Problems) != 3 {
+		t.Errorf("Invalid problems: %v", err)
+		return
+	}
+
+	if err := os.WriteFile(path.Join(fdir, "go.mod"), []byte("module api\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	module := "api"
+	args := map[string]*string{"MODULE": &module}
+	if err := meta.Validate("golang", fdir, args); err != nil {
+		t.Error(err)
+		return
+	}
+
+	applied := meta.Apply(args)
+	if v, ok := applied["GO_VERSION"]; !ok || *v != "1.21" {
+		t.Errorf("Invalid default: %v", applied)
+	}
+}
+
+func Test_MetadataMissing(t *testing.T) {
+	meta, err := LoadMetadata(t.TempDir())
+	if err != nil || meta != nil {
+		t.Errorf("Expected no metadata: %v %v", meta, err)
+	}
+}
+
+func Test_Scaffold(t *testing.T) {
+	tdir := t.TempDir()
+	if err := os.MkdirAll(path.Join(tdir, "starter"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.WriteFile(path.Join(tdir, "starter", "go.mod"), []byte("module api\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.WriteFile(path.Join(tdir, MetadataFile), []byte("name: golang\nstarter: starter\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dst := path.Join(t.TempDir(), "api")
+	if err := Scaffold(context.Background(), tdir, dst); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := os.Stat(path.Join(dst, "go.mod")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := Scaffold(context.Background(), tdir, dst); err == nil {
+		t.Error("Expected existing function to fail")
+	}
+
+	// starters can't reach outside of the template
+	outside := t.TempDir()
+	for _, starter := range []string{"../../..", outside} {
+		if err := os.WriteFile(path.Join(tdir, MetadataFile), []byte("name: golang\nstarter: "+starter+"\n"), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Scaffold(context.Background(), tdir, path.Join(t.TempDir(), "api")); err == nil {
+			t.Errorf("Expected starter %s to fail", starter)
+		}
+	}
+
+	if err := os.Symlink(outside, path.Join(tdir, "linked")); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.WriteFile(path.Join(tdir, MetadataFile), []byte("name: golang\nstarter: linked\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := Scaffold(context.Background(), tdir, path.Join(t.TempDir(), "api")); err == nil {
+		t.Error("Expected a linked starter to fail")
+	}
+
+	// nor can the files in them
+	secret := path.Join(outside, "id_rsa")
+	if err := os.WriteFile(secret, []byte("private key"), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.WriteFile(path.Join(tdir, MetadataFile), []byte("name: golang\nstarter: starter\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, target := range []string{secret, "../../../../../../../../.." + secret} {
+		leak := path.Join(tdir, "starter", "leak")
+		if err := os.Symlink(target, leak); err != nil {
+			t.Error(err)
+			return
+		}
+		dst := path.Join(t.TempDir(), "api")
+		if err := Scaffold(context.Background(), tdir, dst); err == nil {
+			t.Errorf("Expected a link to %s to fail", target)
+		}
+		if _, err := os.Stat(path.Join(dst, "leak")); !os.IsNotExist(err) {
+			t.Errorf("Link to %s copied: %v", target, err)
+		}
+		if err := os.Remove(leak); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// links that stay in the template are fine
+	if err := os.Symlink("go.mod", path.Join(tdir, "starter", "go.sum")); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := Scaffold(context.Background(), tdir, path.Join(t.TempDir(), "api")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,70 @@
+package templater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Scaffold copies the starter files of a template into dst, which must not
+// exist yet.
+func Scaffold(ctx context.Context, templateDir string, dst string) error {
+	meta, err := LoadMetadata(templateDir)
+	if err != nil {
+		return err
+	}
+
+	starter := functionDir
+	if meta != nil && meta.Starter != "" {
+		starter = meta.Starter
+	}
+
+	src := filepath.Join(templateDir, starter)
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return fmt.Errorf("template has no starter files in %s", starter)
+	}
+	if !within(templateDir, src) {
+		return fmt.Errorf("starter %s links outside of the template", starter)
+	}
+	if err := checkLinks(templateDir, src); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return copyDir(ctx, dst, src, false)
+}
+
+// checkLinks makes sure every link in src points inside the template, the
+// copy follows them and would otherwise bring host files into the function
+func checkLinks(templateDir string, src string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 && !within(templateDir, path) {
+			rel, _ := filepath.Rel(src, path)
+			return fmt.Errorf("starter file %s links outside of the template", rel)
+		}
+		return nil
+	})
+}
+
+// within is true when path is in dir once their links are followed
+func within(dir string, path string) bool {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
@@ -0,0 +1,161 @@
+package templater
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+	"github.com/hashicorp/go-getter"
+	"github.com/ryanuber/go-glob"
+)
+
+const defaultTokenUsername = "oauth2"
+
+// source finds the configured source for a template, exact names win over
+// globs and longer globs win over shorter ones.
+func (t *templater) source(name string) manifests.TemplateSource {
+	if src, ok := t.Sources[name]; ok && src.Source != "" {
+		return src
+	}
+
+	var patterns []string
+	for pattern := range t.Sources {
+		if strings.Contains(pattern, "*") && glob.Glob(pattern, name) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	for _, pattern := range patterns {
+		if src := t.Sources[pattern]; src.Source != "" {
+			return src
+		}
+	}
+
+	return manifests.TemplateSource{
+		Source: defaultTemplateLocation,
+	}
+}
+
+// withQuery adds a query parameter to a go-getter source
+func withQuery(src string, key string, value string) string {
+	if value == "" {
+		return src
+	}
+	sep := "?"
+	if strings.Contains(src, "?") {
+		sep = "&"
+	}
+	return src + sep + key + "=" + url.QueryEscape(value)
+}
+
+// withSubdir adds the subdirectory before any query on the source
+func withSubdir(src string, subdir string) string {
+	base, query, ok := strings.Cut(src, "?")
+	base = fmt.Sprintf("%s//%s", strings.TrimSuffix(base, "/"), subdir)
+	if ok {
+		return base + "?" + query
+	}
+	return base
+}
+
+// credentials of a template source, git is given them through its
+// environment so they stay out of urls, the process list and .git/config.
+type credentials struct {
+	sshKey string
+	env    []string
+}
+
+// environ git is run with
+func (c credentials) environ() []string {
+	env := append(os.Environ(), c.env...)
+	if c.sshKey != "" {
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", c.sshKey))
+	}
+	return env
+}
+
+// authenticate works out the credentials of the template source, an ssh key
+// is also added to the go-getter source as it reads it from there.
+func authenticate(src string, ts manifests.TemplateSource) (string, credentials, error) {
+	var creds credentials
+
+	if ts.Token != "" {
+		detected, err := getter.Detect(src, ".", getter.Detectors)
+		if err != nil {
+			return "", creds, err
+		}
+		_, raw, _ := strings.Cut(detected, "::")
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return "", creds, fmt.Errorf("a token needs an http source: %s", src)
+		}
+		creds.env = tokenEnv(u.Scheme+"://"+u.Host+"/", ts)
+	}
+
+	if ts.SSHKey != "" {
+		creds.sshKey = sshKeyPath(ts.SSHKey)
+		raw, err := os.ReadFile(creds.sshKey)
+		if err != nil {
+			return "", creds, err
+		}
+		src = withQuery(src, "sshkey", base64.StdEncoding.EncodeToString(raw))
+	}
+
+	return src, creds, nil
+}
+
+// tokenEnv sends the token as a header to the host, it's added after any git
+// config already in the environment
+func tokenEnv(host string, ts manifests.TemplateSource) []string {
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	return []string{
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s.extraHeader", n, host),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, basicAuth(ts)),
+	}
+}
+
+func basicAuth(ts manifests.TemplateSource) string {
+	username := ts.Username
+	if username == "" {
+		username = defaultTokenUsername
+	}
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + ts.Token))
+}
+
+func sshKeyPath(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return p
+}
+
+// redact removes the token of a template source from an error, however it
+// was encoded
+func redact(err error, ts manifests.TemplateSource) error {
+	if err == nil || ts.Token == "" {
+		return err
+	}
+
+	userinfo := strings.TrimPrefix(url.UserPassword("", ts.Token).String(), ":")
+	msg := err.Error()
+	for _, encoded := range []string{basicAuth(ts), url.QueryEscape(ts.Token), url.PathEscape(ts.Token), userinfo, ts.Token} {
+		msg = strings.ReplaceAll(msg, encoded, "xxxxx")
+	}
+	return errors.New(msg)
+}
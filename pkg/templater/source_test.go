@@ -0,0 +1,96 @@
+package templater
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/contextcloud/ccb/pkg/manifests"
+)
+
+func Test_Source(t *testing.T) {
+	tmpl := &templater{
+		Options: &Options{
+			Sources: map[string]manifests.TemplateSource{
+				"acme-*":    {Source: "github.com/acme/templates"},
+				"acme-go*":  {Source: "github.com/acme/go-templates"},
+				"acme-node": {Source: "github.com/acme/node"},
+			},
+		},
+	}
+
+	tests := map[string]string{
+		"golang":    defaultTemplateLocation,
+		"acme-java": "github.com/acme/templates",
+		"acme-go":   "github.com/acme/go-templates",
+		"acme-node": "github.com/acme/node",
+	}
+	for name, expected := range tests {
+		if src := tmpl.source(name).Source; src != expected {
+			t.Errorf("Invalid source for %s: %s", name, src)
+		}
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	ts := manifests.TemplateSource{Token: "se/cr+et:1"}
+	src, creds, err := authenticate("github.com/acme/templates//golang", ts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// the token goes to git through its environment
+	if src != "github.com/acme/templates//golang" {
+		t.Errorf("Invalid source: %s", src)
+	}
+	env := strings.Join(creds.env, "\n")
+	if !strings.Contains(env, "=http.https://github.com/.extraHeader") || !strings.Contains(env, "=Authorization: Basic "+basicAuth(ts)) {
+		t.Errorf("Invalid env: %v", creds.env)
+	}
+
+	if _, _, err := authenticate("git::ssh://git@github.com/acme/templates", ts); err == nil {
+		t.Error("Expected an error for a token on an ssh source")
+	}
+}
+
+func Test_Redact(t *testing.T) {
+	ts := manifests.TemplateSource{Token: "se/cr+et:1"}
+	leaks := []string{
+		ts.Token,
+		url.QueryEscape(ts.Token),
+		url.UserPassword("oauth2", ts.Token).String(),
+		"Authorization: Basic " + basicAuth(ts),
+	}
+	for _, leak := range leaks {
+		err := redact(errors.New("unable to fetch "+leak), ts)
+		if strings.Contains(err.Error(), "cr+et") || strings.Contains(err.Error(), "cr%2Bet") || strings.Contains(err.Error(), basicAuth(ts)) {
+			t.Errorf("Token not redacted: %s", err)
+		}
+	}
+}
+
+func Test_DownloadSource(t *testing.T) {
+	repo := newGitRepo(t)
+
+	dir := t.TempDir()
+	tmpl := NewTemplater(&Options{
+		WorkingDir: dir,
+		CacheDir:   t.TempDir(),
+		Sources: map[string]manifests.TemplateSource{
+			"go*": {Source: "git::file://" + repo},
+		},
+	})
+	tmpl.AddFunction("api", "golang@v1.0.0")
+
+	if _, err := tmpl.Download(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := os.Stat(path.Join(TemplatePath(dir, "golang@v1.0.0"), "Dockerfile")); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,16 +2,35 @@ package templater
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/contextcloud/ccb/pkg/manifests"
 	"github.com/hashicorp/go-getter"
 	"github.com/neilotoole/errgroup"
 )
 
+var (
+	// ErrUnknownRef when a template ref can't be resolved
+	ErrUnknownRef = errors.New("unknown ref")
+	// ErrHashMismatch when a template doesn't match the lock file
+	ErrHashMismatch = errors.New("template doesn't match lock file")
+	// ErrOffline when a template isn't cached or vendored and the network can't be used
+	ErrOffline = errors.New("templates aren't available offline")
+	// ErrMissingTemplate when a template hasn't been fetched or vendored
+	ErrMissingTemplate = errors.New("template hasn't been fetched")
+)
+
 const defaultTemplateLocation = "github.com/contextcloud/templates"
 const templatesDir = "templates"
+const vendorDir = "vendor"
 const buildDir = "build"
 const functionDir = "function"
 
@@ -20,26 +39,68 @@ type templateFunction struct {
 	Template string
 }
 
+// Options for the templater
+type Options struct {
+	WorkingDir string
+	// CacheDir shared across projects, defaults to DefaultCacheDir
+	CacheDir string
+	// Sources of templates keyed by name or glob, the default is used for the rest
+	Sources map[string]manifests.TemplateSource
+	// Update re-resolves templates instead of using the pins in the lock file
+	Update bool
+	// Offline only uses cached or vendored templates
+	Offline bool
+	// Vendor copies the fetched templates into the vendor directory
+	Vendor bool
+}
+
 // Templater interface
 type Templater interface {
 	AddFunction(name string, template string)
 	Download(ctx context.Context) ([]string, error)
+	List(ctx context.Context, refresh bool) ([]*Index, error)
 }
 
 // NewTemplater will create a new templater
-func NewTemplater(workingDir string) Templater {
+func NewTemplater(opts *Options) Templater {
 	c := &templater{
-		workingDir:        workingDir,
-		templateLocations: make(map[string]string),
+		Options: opts,
 	}
 
 	return c
 }
 
 type templater struct {
-	workingDir        string
-	templateLocations map[string]string
-	functions         []templateFunction
+	*Options
+
+	cache     *Cache
+	functions []templateFunction
+}
+
+// ParseTemplate splits a template into its name and ref, e.g. golang@v1.4.0
+func ParseTemplate(template string) (string, string) {
+	name, ref, _ := strings.Cut(template, "@")
+	return name, ref
+}
+
+// TemplatePath is where a template is fetched to in a working directory
+func TemplatePath(workingDir string, template string) string {
+	return path.Join(workingDir, ".ccb", templatesDir, template)
+}
+
+// VendorPath is where a template is vendored to in a working directory
+func VendorPath(workingDir string, template string) string {
+	return path.Join(workingDir, ".ccb", vendorDir, template)
+}
+
+// FindTemplate returns the fetched template, or the vendored one
+func FindTemplate(workingDir string, template string) (string, error) {
+	for _, p := range []string{TemplatePath(workingDir, template), VendorPath(workingDir, template)} {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrMissingTemplate, template)
 }
 
 // AddFunction will add a name and template
@@ -49,53 +110,243 @@ func (t *templater) AddFunction(name, template string) {
 
 // Download will fetch in parallel
 func (t *templater) Download(ctx context.Context) ([]string, error) {
+	lock, err := LoadLock(t.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := NewCache(t.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	t.cache = cache
+
 	// build a list of functions
-	templates := make(map[string]string)
+	templates := make(map[string]bool)
 	for _, fn := range t.functions {
-		if _, ok := templates[fn.Template]; ok {
-			continue
-		}
-		templates[fn.Template] = t.getTemplate(fn.Template)
+		templates[fn.Template] = true
 	}
 
 	cpus := runtime.NumCPU()
 	g, ctx := errgroup.WithContextN(ctx, cpus, 1)
 
 	var out []string
-	for name, tmpl := range templates {
+	var missing []string
+	var mu sync.Mutex
+	for name := range templates {
 		out = append(out, name)
 
 		n := name
-		v := tmpl
-
 		g.Go(func() error {
-			return t.download(ctx, v, n)
+			entry, err := t.fetch(ctx, lock, n)
+			if errors.Is(err, ErrOffline) {
+				// report every missing template at once
+				mu.Lock()
+				defer mu.Unlock()
+				missing = append(missing, n)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", n, err)
+			}
+			lock.Set(n, entry)
+
+			if t.Vendor {
+				return t.vendor(ctx, n)
+			}
+			return nil
 		})
 	}
 
-	return out, g.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", ErrOffline, strings.Join(missing, ", "))
+	}
+
+	return out, lock.Save(t.WorkingDir)
 }
 
 func (t *templater) getTemplate(template string) string {
 	// get the source.!
-	loc, ok := t.templateLocations[template]
-	if !ok || len(loc) == 0 {
-		loc = defaultTemplateLocation
+	return withSubdir(normalizeSource(t.source(template).Source), template)
+}
+
+// normalizeSource so the same source is always written the same way
+func normalizeSource(loc string) string {
+	// forced getters need the scheme, otherwise let go-getter detect it
+	if !strings.Contains(loc, "::") {
+		loc = strings.TrimPrefix(loc, "https://")
 	}
+	return strings.TrimSuffix(loc, "/")
+}
+
+// fetch a template at its pinned ref, or resolve and pin it when it isn't
+// locked or is being updated.
+func (t *templater) fetch(ctx context.Context, lock *Lock, template string) (*LockEntry, error) {
+	name, ref := ParseTemplate(template)
+	src := t.getTemplate(name)
 
-	loc = strings.TrimPrefix(loc, "https://")
-	loc = strings.TrimSuffix(loc, "/")
+	ts := t.source(name)
+	authSrc, creds, err := authenticate(src, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, locked := lock.Get(template)
+	if locked && (t.Update || entry.Source != src || entry.Ref != ref) {
+		locked = false
+	}
+
+	dst := TemplatePath(t.WorkingDir, template)
+
+	// vendored templates are hermetic, vendoring itself refreshes them
+	vendored := VendorPath(t.WorkingDir, template)
+	if _, err := os.Stat(vendored); err == nil && !t.Vendor && !t.Update {
+		return t.fromVendor(ctx, template, vendored, dst, src, ref, entry, locked)
+	}
+
+	resolved := ref
+	if locked {
+		resolved = entry.Resolved
+	} else if t.Offline {
+		return nil, ErrOffline
+	} else {
+		r, err := resolveRef(ctx, authSrc, creds, ref)
+		if err != nil {
+			return nil, redact(err, ts)
+		}
+		resolved = r
+	}
 
-	return fmt.Sprintf("%s//%s", loc, template)
+	download := func(dst string) error {
+		if t.Offline {
+			return ErrOffline
+		}
+		if err := t.download(ctx, withQuery(authSrc, "ref", resolved), creds, dst); err != nil {
+			return fmt.Errorf("unable to download %s: %w", src, redact(err, ts))
+		}
+		return nil
+	}
+
+	if cacheable(resolved) {
+		if err := t.fromCache(ctx, src, resolved, dst, download); err != nil {
+			return nil, err
+		}
+	} else if err := download(dst); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashDir(dst)
+	if err != nil {
+		return nil, err
+	}
+	if locked && entry.Hash != hash {
+		return nil, fmt.Errorf("%w: %s is %s, locked %s (run fetch --update to refresh)", ErrHashMismatch, template, hash, entry.Hash)
+	}
+
+	return &LockEntry{
+		Source:   src,
+		Ref:      ref,
+		Resolved: resolved,
+		Hash:     hash,
+	}, nil
+}
+
+// fromVendor copies a vendored template into dst, it's checked against the
+// lock file when it's pinned.
+func (t *templater) fromVendor(ctx context.Context, template, vendored, dst, src, ref string, entry *LockEntry, locked bool) (*LockEntry, error) {
+	if err := replaceDir(ctx, dst, vendored); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashDir(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		return &LockEntry{
+			Source: src,
+			Ref:    ref,
+			Hash:   hash,
+		}, nil
+	}
+	if entry.Hash != hash {
+		return nil, fmt.Errorf("%w: vendored %s is %s, locked %s (run vendor to refresh)", ErrHashMismatch, template, hash, entry.Hash)
+	}
+	return entry, nil
+}
+
+// vendor copies a fetched template into the vendor directory
+func (t *templater) vendor(ctx context.Context, template string) error {
+	return replaceDir(ctx, VendorPath(t.WorkingDir, template), TemplatePath(t.WorkingDir, template))
+}
+
+// fromCache copies a template from the cache into dst, downloading it into
+// the cache first when it's missing.
+func (t *templater) fromCache(ctx context.Context, src, resolved, dst string, download func(dst string) error) error {
+	entry, ok := t.cache.Get(src, resolved)
+	if !ok {
+		e, err := t.cache.Put(src, resolved, download)
+		if err != nil {
+			return err
+		}
+		entry = e
+	}
+
+	return replaceDir(ctx, dst, t.cache.Path(entry.Key))
 }
 
-func (t *templater) download(ctx context.Context, repository, template string) error {
+func (t *templater) download(ctx context.Context, repository string, creds credentials, dst string) error {
+	// start clean so removed files don't linger and the hash is stable
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
 	cli := &getter.Client{
 		Ctx:  ctx,
 		Mode: getter.ClientModeDir,
 		Src:  repository,
-		Dst:  fmt.Sprintf(".ccb/%s/%s", templatesDir, template),
-		Pwd:  ".",
+		Dst:  dst,
+		Pwd:  t.WorkingDir,
+	}
+	if err := withEnv(creds.env, cli.Get); err != nil {
+		return err
+	}
+
+	// only the files are kept, the clone of a whole source would otherwise
+	// bring its remote into the cache
+	return os.RemoveAll(filepath.Join(dst, ".git"))
+}
+
+// envMu guards the environment of the process while it has credentials in it
+var envMu sync.Mutex
+
+// withEnv runs fn with env set on the process, go-getter runs git with the
+// environment of the process and nothing else
+func withEnv(env []string, fn func() error) error {
+	if len(env) == 0 {
+		return fn()
+	}
+
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		prev, ok := os.LookupEnv(key)
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+		if ok {
+			defer os.Setenv(key, prev)
+		} else {
+			defer os.Unsetenv(key)
+		}
 	}
-	return cli.Get()
+	return fn()
 }
@@ -0,0 +1,191 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/contextcloud/ccb/pkg/builder"
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+// DefaultDebounce is how long to wait for changes to settle
+const DefaultDebounce = 300 * time.Millisecond
+
+// Watcher reports the functions whose files change, a burst of changes like
+// a save of many files is reported once
+type Watcher struct {
+	log      print.Log
+	fsw      *fsnotify.Watcher
+	debounce time.Duration
+
+	// dirs of the functions by key
+	dirs    map[string]string
+	ignores map[string]*builder.Ignore
+}
+
+// NewWatcher for the function directories by key, files matched by their
+// ignore files are skipped like they are in the build context
+func NewWatcher(log print.Log, dirs map[string]string, debounce time.Duration) (*Watcher, error) {
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		log:      log,
+		fsw:      fsw,
+		debounce: debounce,
+		dirs:     make(map[string]string),
+		ignores:  make(map[string]*builder.Ignore),
+	}
+
+	for key, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			fsw.Close()
+			return nil, err
+		}
+		w.dirs[key] = abs
+		if err := w.reload(key); err != nil {
+			fsw.Close()
+			return nil, err
+		}
+		if err := w.add(key, abs); err != nil {
+			fsw.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Run until ctx is done, changed is called with the keys of the functions that
+// changed. Changes made while changed runs are reported after it returns.
+func (w *Watcher) Run(ctx context.Context, changed func(keys []string)) error {
+	defer w.fsw.Close()
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.log.Printf("Watch error: %s\n", err)
+
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			key, ok := w.handle(ev)
+			if !ok {
+				continue
+			}
+			pending[key] = true
+			timer.Reset(w.debounce)
+
+		case <-timer.C:
+			keys := make([]string, 0, len(pending))
+			for key := range pending {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			pending = make(map[string]bool)
+
+			changed(keys)
+		}
+	}
+}
+
+// handle an event and return the function it belongs to, if it counts
+func (w *Watcher) handle(ev fsnotify.Event) (string, bool) {
+	if ev.Op == fsnotify.Chmod {
+		return "", false
+	}
+
+	key, ok := w.function(ev.Name)
+	if !ok {
+		return "", false
+	}
+
+	// the ignore files change what is ignored
+	dir := w.dirs[key]
+	if filepath.Dir(ev.Name) == dir && builder.IsIgnoreFile(filepath.Base(ev.Name)) {
+		if err := w.reload(key); err != nil {
+			w.log.Printf("%s: %s\n", key, err)
+		}
+		return key, true
+	}
+
+	isDir := false
+	if ev.Has(fsnotify.Create) {
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+			isDir = true
+		}
+	}
+	if w.ignores[key].Ignored(ev.Name, isDir) {
+		return "", false
+	}
+
+	// new directories need watching too
+	if isDir {
+		if err := w.add(key, ev.Name); err != nil {
+			w.log.Printf("%s: %s\n", key, err)
+		}
+	}
+	return key, true
+}
+
+// function a path is in, the deepest directory wins when they're nested
+func (w *Watcher) function(p string) (string, bool) {
+	var found string
+	for key, dir := range w.dirs {
+		if p != dir && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			continue
+		}
+		if found == "" || len(dir) > len(w.dirs[found]) {
+			found = key
+		}
+	}
+	return found, found != ""
+}
+
+func (w *Watcher) reload(key string) error {
+	ignore, err := builder.LoadIgnore(w.dirs[key])
+	if err != nil {
+		return err
+	}
+	w.ignores[key] = ignore
+	return nil
+}
+
+// add a directory and the ones under it, fsnotify isn't recursive
+func (w *Watcher) add(key string, root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if p != w.dirs[key] && w.ignores[key].Ignored(p, true) {
+			return filepath.SkipDir
+		}
+		return w.fsw.Add(p)
+	})
+}
@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/contextcloud/ccb/pkg/print"
+)
+
+func Test_Watcher(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"api", "worker", "api/pkg"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "api", ".gitignore"), []byte("*.log\n"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	w, err := NewWatcher(print.NewLog(io.Discard), map[string]string{
+		"api":    filepath.Join(dir, "api"),
+		"worker": filepath.Join(dir, "worker"),
+	}, 50*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	changes := make(chan []string, 10)
+	go w.Run(ctx, func(keys []string) {
+		changes <- keys
+	})
+
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// ignored files don't count
+	write("api/debug.log")
+	select {
+	case keys := <-changes:
+		t.Errorf("expected no change, got %v", keys)
+		return
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a burst is one change
+	write("api/main.go")
+	write("api/pkg/handler.go")
+	write("api/main.go")
+
+	select {
+	case keys := <-changes:
+		if len(keys) != 1 || keys[0] != "api" {
+			t.Errorf("expected [api], got %v", keys)
+			return
+		}
+	case <-ctx.Done():
+		t.Error("no change for api")
+		return
+	}
+
+	// new directories are watched
+	if err := os.MkdirAll(filepath.Join(dir, "worker", "jobs"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	<-changes
+	write("worker/jobs/nightly.go")
+
+	select {
+	case keys := <-changes:
+		if len(keys) != 1 || keys[0] != "worker" {
+			t.Errorf("expected [worker], got %v", keys)
+			return
+		}
+	case <-ctx.Done():
+		t.Error("no change for worker")
+	}
+}